@@ -0,0 +1,349 @@
+package upcloud
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+
+	upcloud "github.com/Jalle19/upcloud-go-sdk/upcloud"
+
+	api_operation "github.com/wunderkraut/radi-api/operation"
+	api_property "github.com/wunderkraut/radi-api/property"
+	api_result "github.com/wunderkraut/radi-api/result"
+	api_usage "github.com/wunderkraut/radi-api/usage"
+)
+
+const (
+	UPCLOUD_ANSIBLE_FORMAT_INI  = "ini"
+	UPCLOUD_ANSIBLE_FORMAT_YAML = "yaml"
+	UPCLOUD_ANSIBLE_FORMAT_JSON = "json"
+	// an executable dynamic inventory script, which runs this operation
+	UPCLOUD_ANSIBLE_FORMAT_SCRIPT = "script"
+)
+
+/**
+ * Ansible inventories of the provisioned project servers
+ *
+ * Hosts are named by their server id, and grouped into:
+ *
+ *   role_<role>   the Roles of the server definition
+ *   zone_<zone>   the UpCloud zone of the server
+ *   tag_<tag>     the UpCloud tags on the server
+ */
+
+// Ansible inventory operation
+type UpcloudMonitorAnsibleInventoryOperation struct {
+	BaseUpcloudServiceOperation
+}
+
+// Return the string machinename/id of the Operation
+func (inventory *UpcloudMonitorAnsibleInventoryOperation) Id() string {
+	return "upcloud.monitor.ansible.inventory"
+}
+
+// Return a user readable string label for the Operation
+func (inventory *UpcloudMonitorAnsibleInventoryOperation) Label() string {
+	return "Ansible inventory"
+}
+
+// return a multiline string description for the Operation
+func (inventory *UpcloudMonitorAnsibleInventoryOperation) Description() string {
+	return "Generate an Ansible inventory from the provisioned project servers."
+}
+
+// return a multiline string man page for the Operation
+func (inventory *UpcloudMonitorAnsibleInventoryOperation) Help() string {
+	return `Formats are ini (default), yaml, json and script.
+
+The json format is the Ansible dynamic inventory format, including
+_meta hostvars.  If a host is given, only the vars of that host are
+output, which answers the dynamic inventory --host call.
+
+The script format writes an executable dynamic inventory script for
+the project, which answers --list and --host <name> by running this
+operation, so that the inventory is always current:
+
+  radi upcloud.monitor.ansible.inventory --upcloud.format=script --upcloud.output=inventory.sh
+  ansible-playbook -i inventory.sh site.yml
+
+The script runs "radi", or the command in the RADI environment variable.`
+}
+
+// Is this operation meant to be used only inside the API
+func (inventory *UpcloudMonitorAnsibleInventoryOperation) Usage() api_usage.Usage {
+	return api_operation.Usage_External()
+}
+
+// Run a validation check on the Operation
+func (inventory *UpcloudMonitorAnsibleInventoryOperation) Validate() api_result.Result {
+	return api_result.MakeSuccessfulResult()
+}
+
+// What settings/values does the Operation provide to an implemenentor
+func (inventory *UpcloudMonitorAnsibleInventoryOperation) Properties() api_property.Properties {
+	props := api_property.New_SimplePropertiesEmpty()
+
+	props.Add(api_property.Property(&UpcloudFormatProperty{}))
+	props.Add(api_property.Property(&UpcloudOutputProperty{}))
+	props.Add(api_property.Property(&UpcloudHostProperty{}))
+
+	return props.Properties()
+}
+
+// Execute the Operation
+func (inventory *UpcloudMonitorAnsibleInventoryOperation) Exec(props api_property.Properties) api_result.Result {
+	res := api_result.New_StandardResult()
+
+	format := UPCLOUD_ANSIBLE_FORMAT_INI
+	if formatProp, found := props.Get(UPCLOUD_FORMAT_PROPERTY); found && formatProp.Get().(string) != "" {
+		format = formatProp.Get().(string)
+	}
+	output := ""
+	if outputProp, found := props.Get(UPCLOUD_OUTPUT_PROPERTY); found {
+		output = outputProp.Get().(string)
+	}
+	host := ""
+	if hostProp, found := props.Get(UPCLOUD_HOST_PROPERTY); found {
+		host = hostProp.Get().(string)
+	}
+
+	ansible, errs := buildAnsibleInventory(inventory.ServerDefinitions(), inventory.BuilderSettings())
+	if len(errs) > 0 {
+		res.AddErrors(errs)
+		res.MarkFailed()
+	}
+
+	var source []byte
+	var err error
+	if host != "" {
+		// dynamic inventory --host mode
+		if vars, found := ansible.hostvars[host]; found {
+			source, err = json.MarshalIndent(vars, "", "  ")
+		} else {
+			err = errors.New("Unknown inventory host : " + host)
+		}
+	} else if format == UPCLOUD_ANSIBLE_FORMAT_SCRIPT {
+		source = ansibleInventoryScript(projectRoot(), inventory.Id())
+	} else {
+		switch format {
+		case UPCLOUD_ANSIBLE_FORMAT_INI:
+			source = ansible.Ini()
+		case UPCLOUD_ANSIBLE_FORMAT_YAML:
+			source, err = ansible.Yaml()
+		case UPCLOUD_ANSIBLE_FORMAT_JSON:
+			source, err = ansible.Json()
+		default:
+			err = errors.New("Unknown Ansible inventory format : " + format)
+		}
+	}
+	if err == nil {
+		err = writeOutput(output, source)
+	}
+	if err == nil && output != "" && host == "" && format == UPCLOUD_ANSIBLE_FORMAT_SCRIPT {
+		err = os.Chmod(output, 0755)
+	}
+	if err != nil {
+		res.AddError(err)
+		res.AddError(errors.New("Could not generate the Ansible inventory."))
+		res.MarkFailed()
+		res.MarkFinished()
+		return res.Result()
+	}
+
+	log.WithFields(log.Fields{"hosts": len(ansible.hosts), "groups": len(ansible.groups), "format": format}).Debug("Ansible inventory generated")
+
+	if len(errs) == 0 {
+		res.MarkSuccess()
+	}
+	res.MarkFinished()
+
+	return res.Result()
+}
+
+// An executable Ansible dynamic inventory script, which runs the inventory operation in the project
+func ansibleInventoryScript(root string, operation string) []byte {
+	quotedRoot := "'" + strings.Replace(root, "'", "'\\''", -1) + "'"
+	return []byte(`#!/bin/sh
+# Ansible dynamic inventory of the UpCloud servers of the radi project in ` + root + `
+RADI="${RADI:-radi}"
+cd ` + quotedRoot + ` || exit 1
+case "$1" in
+	--list)
+		exec "$RADI" ` + operation + ` --` + UPCLOUD_FORMAT_PROPERTY + `=` + UPCLOUD_ANSIBLE_FORMAT_JSON + `
+		;;
+	--host)
+		exec "$RADI" ` + operation + ` --` + UPCLOUD_HOST_PROPERTY + `="$2"
+		;;
+	*)
+		echo "Usage: $0 --list | --host <hostname>" >&2
+		exit 1
+		;;
+esac
+`)
+}
+
+// An Ansible inventory of hosts, their vars, and groups
+type ansibleInventory struct {
+	hosts    []string
+	hostvars map[string]map[string]string
+	groups   map[string][]string
+}
+
+// Build an inventory from the provisioned project servers
+func buildAnsibleInventory(serverDefinitions *ServerDefinitions, settings *UpcloudBuilderSettings) (ansibleInventory, []error) {
+	inventory := ansibleInventory{
+		hosts:    []string{},
+		hostvars: map[string]map[string]string{},
+		groups:   map[string][]string{},
+	}
+	errs := []error{}
+
+	for _, id := range serverDefinitions.Order() {
+		serverDefinition, _ := serverDefinitions.Get(id)
+		if !serverDefinition.IsCreated() {
+			continue
+		}
+		details, err := serverDefinition.GetServerDetails()
+		if err != nil {
+			errs = append(errs, err)
+			errs = append(errs, errors.New("Could not retrieve server details for the inventory : "+id))
+			continue
+		}
+
+		user := settings.SSH.User
+		if request := serverDefinition.CreateServerRequest(); request.LoginUser != nil && request.LoginUser.Username != "" {
+			user = request.LoginUser.Username
+		}
+
+		vars := map[string]string{
+			"ansible_host":         serverAddress(details, upcloud.IPAddressAccessPublic, upcloud.IPAddressFamilyIPv4),
+			"ansible_user":         user,
+			"upcloud_id":           id,
+			"upcloud_uuid":         details.UUID,
+			"upcloud_hostname":     details.Hostname,
+			"upcloud_zone":         details.Zone,
+			"upcloud_plan":         details.Plan,
+			"upcloud_state":        details.State,
+			"upcloud_public_ipv4":  serverAddress(details, upcloud.IPAddressAccessPublic, upcloud.IPAddressFamilyIPv4),
+			"upcloud_private_ipv4": serverAddress(details, upcloud.IPAddressAccessPrivate, upcloud.IPAddressFamilyIPv4),
+			"upcloud_public_ipv6":  serverAddress(details, upcloud.IPAddressAccessPublic, upcloud.IPAddressFamilyIPv6),
+		}
+		if settings.SSH.Port > 0 {
+			vars["ansible_port"] = strconv.Itoa(settings.SSH.Port)
+		}
+		if settings.SSH.KeyFile != "" {
+			vars["ansible_ssh_private_key_file"] = projectPath(settings.SSH.KeyFile)
+		}
+		for key, value := range vars {
+			if value == "" {
+				delete(vars, key)
+			}
+		}
+
+		inventory.hosts = append(inventory.hosts, id)
+		inventory.hostvars[id] = vars
+
+		for _, role := range serverDefinition.Roles() {
+			inventory.addToGroup("role_"+role, id)
+		}
+		if details.Zone != "" {
+			inventory.addToGroup("zone_"+details.Zone, id)
+		}
+		for _, tag := range details.Tags {
+			inventory.addToGroup("tag_"+tag, id)
+		}
+	}
+
+	return inventory, errs
+}
+
+// Characters which are not valid in Ansible group names
+var ansibleGroupInvalid = regexp.MustCompile("[^a-z0-9_]")
+
+// Add a host to a group, using a valid Ansible group name
+func (inventory *ansibleInventory) addToGroup(group string, host string) {
+	group = ansibleGroupInvalid.ReplaceAllString(strings.ToLower(group), "_")
+	for _, existing := range inventory.groups[group] {
+		if existing == host {
+			return
+		}
+	}
+	inventory.groups[group] = append(inventory.groups[group], host)
+}
+
+// The group names, sorted
+func (inventory *ansibleInventory) groupNames() []string {
+	names := []string{}
+	for name := range inventory.groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// The inventory in the Ansible INI format
+func (inventory *ansibleInventory) Ini() []byte {
+	var ini bytes.Buffer
+	for _, host := range inventory.hosts {
+		ini.WriteString(host)
+		vars := inventory.hostvars[host]
+		keys := []string{}
+		for key := range vars {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			ini.WriteString(" " + key + "=" + strconv.Quote(vars[key]))
+		}
+		ini.WriteString("\n")
+	}
+	for _, group := range inventory.groupNames() {
+		ini.WriteString("\n[" + group + "]\n")
+		for _, host := range inventory.groups[group] {
+			ini.WriteString(host + "\n")
+		}
+	}
+	return ini.Bytes()
+}
+
+// The inventory in the Ansible YAML format
+func (inventory *ansibleInventory) Yaml() ([]byte, error) {
+	hosts := map[string]map[string]string{}
+	for _, host := range inventory.hosts {
+		hosts[host] = inventory.hostvars[host]
+	}
+	children := map[string]map[string]map[string]map[string]string{}
+	for _, group := range inventory.groupNames() {
+		members := map[string]map[string]string{}
+		for _, host := range inventory.groups[group] {
+			members[host] = map[string]string{}
+		}
+		children[group] = map[string]map[string]map[string]string{"hosts": members}
+	}
+
+	all := map[string]interface{}{"hosts": hosts}
+	if len(children) > 0 {
+		all["children"] = children
+	}
+	return yaml.Marshal(map[string]interface{}{"all": all})
+}
+
+// The inventory in the Ansible dynamic inventory JSON format
+func (inventory *ansibleInventory) Json() ([]byte, error) {
+	list := map[string]interface{}{
+		"_meta": map[string]interface{}{"hostvars": inventory.hostvars},
+		"all":   map[string]interface{}{"hosts": inventory.hosts},
+	}
+	for _, group := range inventory.groupNames() {
+		list[group] = map[string]interface{}{"hosts": inventory.groups[group]}
+	}
+	return json.MarshalIndent(list, "", "  ")
+}
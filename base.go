@@ -1,6 +1,12 @@
 package upcloud
 
 import (
+	"errors"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	api_property "github.com/wunderkraut/radi-api/property"
 	api_result "github.com/wunderkraut/radi-api/result"
 )
 
@@ -43,6 +49,11 @@ func (base *BaseUpcloudServiceHandler) BuilderSettings() *UpcloudBuilderSettings
 	return base.builderSettings
 }
 
+// Is UpCloud access read only, from either the settings or the access config?
+func (base *BaseUpcloudServiceHandler) ReadOnly() bool {
+	return base.builderSettings.ReadOnly || base.factory.ReadOnly()
+}
+
 /**
  * Base operations for Upcloud operations, which
  * allow sharing of Upcloud service across instances
@@ -64,7 +75,10 @@ func New_BaseUpcloudServiceOperation(factory UpcloudFactory, builderSettings *Up
 
 // Set the service
 func (base *BaseUpcloudServiceOperation) ServiceWrapper() *UpcloudServiceWrapper {
-	return base.factory.ServiceWrapper()
+	wrapper := base.factory.ServiceWrapper()
+	wrapper.SetBuilderSettings(base.builderSettings)
+	wrapper.SetProjectScope(New_UpcloudProjectScope(base.State(), base.ServerDefinitions()))
+	return wrapper
 }
 
 // Get the service
@@ -73,7 +87,34 @@ func (base *BaseUpcloudServiceOperation) ServerDefinitions() *ServerDefinitions
 	return &defs
 }
 
+// Get the configured Count of each replica group
+func (base *BaseUpcloudServiceOperation) ReplicaGroups() map[string]int {
+	return base.factory.ReplicaGroups()
+}
+
+// Get the local provisioning state
+func (base *BaseUpcloudServiceOperation) State() *UpcloudState {
+	return base.factory.State()
+}
+
 // Get the settings
 func (base *BaseUpcloudServiceOperation) BuilderSettings() *UpcloudBuilderSettings {
 	return base.builderSettings
 }
+
+// Get the timeout for a type of action, which the timeout property can override
+func (base *BaseUpcloudServiceOperation) Timeout(action string, props api_property.Properties) time.Duration {
+	if timeoutProp, found := props.Get(UPCLOUD_TIMEOUT_PROPERTY); found {
+		if value := timeoutProp.Get().(string); value != "" {
+			timeout, err := time.ParseDuration(value)
+			if err == nil && timeout <= 0 {
+				err = errors.New("The timeout must be positive")
+			}
+			if err == nil {
+				return timeout
+			}
+			log.WithError(err).WithFields(log.Fields{"key": UPCLOUD_TIMEOUT_PROPERTY, "value": value}).Warn("Could not use timeout property, using the settings timeout")
+		}
+	}
+	return base.builderSettings.Timeouts.Timeout(action)
+}
@@ -55,6 +55,11 @@ func (builder *UpcloudBuilder) Activate(implementations api_builder.Implementati
 	for _, implementation := range implementations.Order() {
 		var handler api_handler.Handler
 
+		if baseHandler.ReadOnly() && (implementation == "server" || implementation == "provision") {
+			log.WithFields(log.Fields{"implementation": implementation}).Info("UpCloud access is read only, skipping mutating implementation")
+			continue
+		}
+
 		switch implementation {
 		case "monitor":
 			handler = api_handler.Handler(&UpcloudMonitorHandler{BaseUpcloudServiceHandler: *baseHandler})
@@ -64,6 +69,8 @@ func (builder *UpcloudBuilder) Activate(implementations api_builder.Implementati
 			handler = api_handler.Handler(&UpcloudProvisionHandler{BaseUpcloudServiceHandler: *baseHandler})
 		case "security":
 			handler = api_handler.Handler(&UpcloudSecurityHandler{BaseUpcloudServiceHandler: *baseHandler})
+		case "config":
+			handler = api_handler.Handler(&UpcloudConfigHandler{BaseUpcloudServiceHandler: *baseHandler})
 		default:
 			log.WithFields(log.Fields{"implementation": implementation}).Error("Unknown implementation in UpCloud builder")
 		}
@@ -103,6 +110,12 @@ func (builder *UpcloudBuilder) base_BaseUpcloudServiceHandler() *BaseUpcloudServ
 		// get an upcloud factory, using the config wrapper (probably a file like upcloud.yml)
 		upcloudFactory := New_UpcloudFactoryConfigWrapperYaml(configWrapper)
 		upcloudFactory.Load()
+		// keep a local state of provisioned resources
+		state := New_UpcloudState(builder.settings.StateFile)
+		if err := state.Load(); err != nil {
+			log.WithError(err).WithFields(log.Fields{"path": state.Path()}).Error("Could not load UpCloud state file")
+		}
+		upcloudFactory.SetState(state)
 
 		// Builder the base operation, and keep it
 		builder.base_UpcloudServiceHandler = New_BaseUpcloudServiceHandler(upcloudFactory.UpcloudFactory(), &builder.settings)
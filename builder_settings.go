@@ -1,6 +1,9 @@
 package upcloud
 
 import (
+	"errors"
+	"time"
+
 	log "github.com/Sirupsen/logrus"
 
 	upcloud "github.com/Jalle19/upcloud-go-sdk/upcloud"
@@ -12,6 +15,20 @@ type UpcloudBuilderSettings struct {
 	Hosts    []string `yml:"Hosts"`
 	Zones    []string `yml:"Zones"`
 	Storages []string `yml:"Storages"`
+
+	Budget  UpcloudBuilderSettings_Budget  `yml:"Budget"`
+	Credits UpcloudBuilderSettings_Credits `yml:"Credits"`
+
+	// Roll back servers created in a failed provisioning run
+	Rollback bool `yml:"Rollback"`
+	// Refuse all mutating UpCloud calls, and skip mutating handlers
+	ReadOnly bool `yml:"ReadOnly"`
+	// Path to the local provisioning state file, relative to the project
+	StateFile string `yml:"StateFile"`
+
+	Timeouts UpcloudBuilderSettings_Timeouts `yml:"Timeouts"`
+	Retry    UpcloudBuilderSettings_Retry    `yml:"Retry"`
+	SSH      UpcloudBuilderSettings_SSH      `yml:"SSH"`
 }
 
 // Merge settings
@@ -30,17 +47,87 @@ func (settings *UpcloudBuilderSettings) Merge(merge UpcloudBuilderSettings) {
 		}
 	}
 
+	// merge tags
+	for _, tag := range merge.Tags {
+		exists := false
+		for _, existing := range settings.Tags {
+			if existing == tag {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			settings.Tags = append(settings.Tags, tag)
+		}
+	}
+
+	// merge zones
+	for _, zone := range merge.Zones {
+		exists := false
+		for _, existing := range settings.Zones {
+			if existing == zone {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			settings.Zones = append(settings.Zones, zone)
+		}
+	}
+
+	// merge storages
+	for _, storage := range merge.Storages {
+		exists := false
+		for _, existing := range settings.Storages {
+			if existing == storage {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			settings.Storages = append(settings.Storages, storage)
+		}
+	}
+
+	settings.Budget.Merge(merge.Budget)
+	settings.Credits.Merge(merge.Credits)
+	if merge.Rollback {
+		settings.Rollback = true
+	}
+	if merge.ReadOnly {
+		settings.ReadOnly = true
+	}
+	if merge.StateFile != "" {
+		settings.StateFile = merge.StateFile
+	}
+	settings.Timeouts.Merge(merge.Timeouts)
+	settings.Retry.Merge(merge.Retry)
+	settings.SSH.Merge(merge.SSH)
+
 	log.WithFields(log.Fields{"settings": settings}).Debug("Merged UpCloud settings")
 }
 
 // It doesn't want to automatically marshal, so do it manually @TODO why isn't it unmarshalling automatically?
 func (settings *UpcloudBuilderSettings) UnmarshalYAML(unmarshal func(interface{}) error) error {
-	placeholder := map[string][]string{}
+	placeholder := struct {
+		Hosts     []string                        `yaml:"Hosts"`
+		Tags      []string                        `yaml:"Tags"`
+		Zones     []string                        `yaml:"Zones"`
+		Storages  []string                        `yaml:"Storages"`
+		Budget    UpcloudBuilderSettings_Budget   `yaml:"Budget"`
+		Credits   UpcloudBuilderSettings_Credits  `yaml:"Credits"`
+		Rollback  bool                            `yaml:"Rollback"`
+		ReadOnly  bool                            `yaml:"ReadOnly"`
+		StateFile string                          `yaml:"StateFile"`
+		Timeouts  UpcloudBuilderSettings_Timeouts `yaml:"Timeouts"`
+		Retry     UpcloudBuilderSettings_Retry    `yaml:"Retry"`
+		SSH       UpcloudBuilderSettings_SSH      `yaml:"SSH"`
+	}{}
 	if err := unmarshal(&placeholder); err != nil {
 		return err
 	}
 
-	if hosts, defined := placeholder["Hosts"]; defined {
+	if hosts := placeholder.Hosts; len(hosts) > 0 {
 		for _, host := range hosts {
 			exists := false
 			for _, existing := range settings.Hosts {
@@ -54,7 +141,7 @@ func (settings *UpcloudBuilderSettings) UnmarshalYAML(unmarshal func(interface{}
 			}
 		}
 	}
-	if tags, defined := placeholder["Tags"]; defined {
+	if tags := placeholder.Tags; len(tags) > 0 {
 		for _, tag := range tags {
 			exists := false
 			for _, existing := range settings.Tags {
@@ -68,7 +155,7 @@ func (settings *UpcloudBuilderSettings) UnmarshalYAML(unmarshal func(interface{}
 			}
 		}
 	}
-	if zones, defined := placeholder["Zones"]; defined {
+	if zones := placeholder.Zones; len(zones) > 0 {
 		for _, zone := range zones {
 			exists := false
 			for _, existing := range settings.Zones {
@@ -82,16 +169,44 @@ func (settings *UpcloudBuilderSettings) UnmarshalYAML(unmarshal func(interface{}
 			}
 		}
 	}
+	if storages := placeholder.Storages; len(storages) > 0 {
+		for _, storage := range storages {
+			exists := false
+			for _, existing := range settings.Storages {
+				if existing == storage {
+					exists = true
+					break
+				}
+			}
+			if !exists {
+				settings.Storages = append(settings.Storages, storage)
+			}
+		}
+	}
+	settings.Budget.Merge(placeholder.Budget)
+	settings.Credits.Merge(placeholder.Credits)
+	if placeholder.Rollback {
+		settings.Rollback = true
+	}
+	if placeholder.ReadOnly {
+		settings.ReadOnly = true
+	}
+	if placeholder.StateFile != "" {
+		settings.StateFile = placeholder.StateFile
+	}
+	settings.Timeouts.Merge(placeholder.Timeouts)
+	settings.Retry.Merge(placeholder.Retry)
+	settings.SSH.Merge(placeholder.SSH)
 	return nil
 }
 
-// Does this server match settings from the BuilderSettings (is it in this project)
+// Does this server match settings from the BuilderSettings, for listing servers (no Hosts lists all servers)
 func (settings *UpcloudBuilderSettings) ServerUUIDAllowed(uuid string) bool {
-	if len(settings.Hosts) == 0 {
-		return true
-	}
+	return len(settings.Hosts) == 0 || settings.HostsInclude(uuid)
+}
 
-	// simple host UUID match
+// Is this server explicitly listed in the Hosts setting?
+func (settings *UpcloudBuilderSettings) HostsInclude(uuid string) bool {
 	for _, match := range settings.Hosts {
 		if match == uuid {
 			return true
@@ -100,13 +215,13 @@ func (settings *UpcloudBuilderSettings) ServerUUIDAllowed(uuid string) bool {
 	return false
 }
 
-// Does this storage match settings from the BuilderSettings (is it in this project)
+// Does this storage match settings from the BuilderSettings, for listing storages (no Storages lists all storages)
 func (settings *UpcloudBuilderSettings) StorageUUIDAllowed(uuid string) bool {
-	if len(settings.Storages) == 0 {
-		return true
-	}
+	return len(settings.Storages) == 0 || settings.StoragesInclude(uuid)
+}
 
-	// simple host UUID match
+// Is this storage explicitly listed in the Storages setting?
+func (settings *UpcloudBuilderSettings) StoragesInclude(uuid string) bool {
 	for _, match := range settings.Storages {
 		if match == uuid {
 			return true
@@ -129,3 +244,234 @@ func (settings *UpcloudBuilderSettings) ZoneAllowed(zone upcloud.Zone) bool {
 	}
 	return false
 }
+
+// A budget cap for the project, in UpCloud credits.  A zero value means no cap.
+type UpcloudBuilderSettings_Budget struct {
+	Hourly  float64 `yaml:"Hourly"`
+	Monthly float64 `yaml:"Monthly"`
+}
+
+// Merge budget settings, any value set in the merge overrides the existing value
+func (budget *UpcloudBuilderSettings_Budget) Merge(merge UpcloudBuilderSettings_Budget) {
+	if merge.Hourly > 0 {
+		budget.Hourly = merge.Hourly
+	}
+	if merge.Monthly > 0 {
+		budget.Monthly = merge.Monthly
+	}
+}
+
+// Is there any budget cap?
+func (budget *UpcloudBuilderSettings_Budget) Empty() bool {
+	return budget.Hourly <= 0 && budget.Monthly <= 0
+}
+
+// Check an estimate against the budget cap, returning an error if it is exceeded
+func (budget *UpcloudBuilderSettings_Budget) Check(estimate *costEstimate) error {
+	if budget.Hourly > 0 && estimate.Hourly() > budget.Hourly {
+		return errors.New("Estimated hourly cost " + formatCredits(estimate.Hourly()) + " exceeds the hourly budget " + formatCredits(budget.Hourly))
+	}
+	if budget.Monthly > 0 && estimate.Monthly() > budget.Monthly {
+		return errors.New("Estimated monthly cost " + formatCredits(estimate.Monthly()) + " exceeds the monthly budget " + formatCredits(budget.Monthly))
+	}
+	return nil
+}
+
+// Account credit thresholds.  A zero value means no threshold.
+//   - below a Minimum the account is considered unusable
+//   - below a Warning the account is usable, but should be topped up
+type UpcloudBuilderSettings_Credits struct {
+	Minimum     float64 `yaml:"Minimum"`
+	Warning     float64 `yaml:"Warning"`
+	MinimumDays float64 `yaml:"MinimumDays"`
+	WarningDays float64 `yaml:"WarningDays"`
+}
+
+// Merge credit settings, any value set in the merge overrides the existing value
+func (credits *UpcloudBuilderSettings_Credits) Merge(merge UpcloudBuilderSettings_Credits) {
+	if merge.Minimum > 0 {
+		credits.Minimum = merge.Minimum
+	}
+	if merge.Warning > 0 {
+		credits.Warning = merge.Warning
+	}
+	if merge.MinimumDays > 0 {
+		credits.MinimumDays = merge.MinimumDays
+	}
+	if merge.WarningDays > 0 {
+		credits.WarningDays = merge.WarningDays
+	}
+}
+
+// Check an account balance against the minimum thresholds, where days is the estimated days remaining (negative if unknown)
+func (credits *UpcloudBuilderSettings_Credits) CheckMinimum(balance, days float64) error {
+	if balance <= 0 {
+		return errors.New("UpCloud account has no credits")
+	}
+	if credits.Minimum > 0 && balance < credits.Minimum {
+		return errors.New("UpCloud account credits " + formatCredits(balance) + " are below the minimum " + formatCredits(credits.Minimum))
+	}
+	if credits.MinimumDays > 0 && days >= 0 && days < credits.MinimumDays {
+		return errors.New("UpCloud account credits will run out in " + formatCredits(days) + " days, below the minimum of " + formatCredits(credits.MinimumDays))
+	}
+	return nil
+}
+
+// Check an account balance against the warning thresholds, where days is the estimated days remaining (negative if unknown)
+func (credits *UpcloudBuilderSettings_Credits) CheckWarning(balance, days float64) error {
+	if credits.Warning > 0 && balance < credits.Warning {
+		return errors.New("UpCloud account credits " + formatCredits(balance) + " are below the warning level " + formatCredits(credits.Warning))
+	}
+	if credits.WarningDays > 0 && days >= 0 && days < credits.WarningDays {
+		return errors.New("UpCloud account credits will run out in " + formatCredits(days) + " days, below the warning level of " + formatCredits(credits.WarningDays))
+	}
+	return nil
+}
+
+const (
+	UPCLOUD_TIMEOUT_CREATE = "create"
+	UPCLOUD_TIMEOUT_START  = "start"
+	UPCLOUD_TIMEOUT_STOP   = "stop"
+	UPCLOUD_TIMEOUT_DELETE = "delete"
+)
+
+// Timeouts for waiting on UpCloud actions, as duration strings like "2m" or "90s"
+type UpcloudBuilderSettings_Timeouts struct {
+	Create string `yaml:"Create"`
+	Start  string `yaml:"Start"`
+	Stop   string `yaml:"Stop"`
+	Delete string `yaml:"Delete"`
+}
+
+// Merge timeout settings, any value set in the merge overrides the existing value
+func (timeouts *UpcloudBuilderSettings_Timeouts) Merge(merge UpcloudBuilderSettings_Timeouts) {
+	if merge.Create != "" {
+		timeouts.Create = merge.Create
+	}
+	if merge.Start != "" {
+		timeouts.Start = merge.Start
+	}
+	if merge.Stop != "" {
+		timeouts.Stop = merge.Stop
+	}
+	if merge.Delete != "" {
+		timeouts.Delete = merge.Delete
+	}
+}
+
+// Get the timeout for a type of action, falling back to a default if it is not set, or is not a positive duration
+func (timeouts *UpcloudBuilderSettings_Timeouts) Timeout(action string) time.Duration {
+	value := ""
+	fallback := time.Minute * 2
+	switch action {
+	case UPCLOUD_TIMEOUT_CREATE:
+		value = timeouts.Create
+	case UPCLOUD_TIMEOUT_START:
+		value = timeouts.Start
+	case UPCLOUD_TIMEOUT_STOP:
+		value = timeouts.Stop
+	case UPCLOUD_TIMEOUT_DELETE:
+		value = timeouts.Delete
+		fallback = time.Minute
+	}
+
+	if value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err == nil && timeout <= 0 {
+			err = errors.New("The timeout must be positive")
+		}
+		if err == nil {
+			return timeout
+		}
+		log.WithError(err).WithFields(log.Fields{"action": action, "timeout": value}).Warn("Could not use UpCloud timeout setting, using the default")
+	}
+	return fallback
+}
+
+// Retry policy for transient UpCloud API failures
+type UpcloudBuilderSettings_Retry struct {
+	Attempts int    `yaml:"Attempts"`
+	Delay    string `yaml:"Delay"`
+	MaxDelay string `yaml:"MaxDelay"`
+}
+
+// Merge retry settings, any value set in the merge overrides the existing value
+func (retry *UpcloudBuilderSettings_Retry) Merge(merge UpcloudBuilderSettings_Retry) {
+	if merge.Attempts != 0 {
+		retry.Attempts = merge.Attempts
+	}
+	if merge.Delay != "" {
+		retry.Delay = merge.Delay
+	}
+	if merge.MaxDelay != "" {
+		retry.MaxDelay = merge.MaxDelay
+	}
+}
+
+// Convert the settings into a retry policy, using defaults for anything not set or not valid
+func (retry *UpcloudBuilderSettings_Retry) Policy() UpcloudRetryPolicy {
+	policy := New_UpcloudRetryPolicy()
+	if retry.Attempts > 0 {
+		policy.Attempts = retry.Attempts
+	} else if retry.Attempts < 0 {
+		log.WithFields(log.Fields{"attempts": retry.Attempts}).Error("UpCloud retry attempts must be positive, using the default")
+	}
+	if delay, ok := retry.duration("Delay", retry.Delay); ok {
+		policy.Delay = delay
+	}
+	if maxDelay, ok := retry.duration("MaxDelay", retry.MaxDelay); ok {
+		policy.MaxDelay = maxDelay
+	}
+	if policy.MaxDelay < policy.Delay {
+		policy.MaxDelay = policy.Delay
+	}
+	return policy
+}
+
+// Parse a retry delay setting, which must be a positive duration
+func (retry *UpcloudBuilderSettings_Retry) duration(name string, value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	delay, err := time.ParseDuration(value)
+	if err == nil && delay <= 0 {
+		err = errors.New("The delay must be positive")
+	}
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{"setting": name, "delay": value}).Error("Could not use UpCloud retry setting, using the default")
+		return 0, false
+	}
+	return delay, true
+}
+
+// SSH access to project servers
+type UpcloudBuilderSettings_SSH struct {
+	// User to connect as, if the server has no login user
+	User string `yaml:"User"`
+	// Private key file, relative to the project
+	KeyFile string `yaml:"KeyFile"`
+	Port    int    `yaml:"Port"`
+	// Extra ssh -o options, like "StrictHostKeyChecking=yes"
+	Options []string `yaml:"Options"`
+	// Timeout for connecting
+	ConnectTimeout string `yaml:"ConnectTimeout"`
+}
+
+// Merge ssh settings, any value set in the merge overrides the existing value
+func (ssh *UpcloudBuilderSettings_SSH) Merge(merge UpcloudBuilderSettings_SSH) {
+	if merge.User != "" {
+		ssh.User = merge.User
+	}
+	if merge.KeyFile != "" {
+		ssh.KeyFile = merge.KeyFile
+	}
+	if merge.Port > 0 {
+		ssh.Port = merge.Port
+	}
+	if len(merge.Options) > 0 {
+		ssh.Options = merge.Options
+	}
+	if merge.ConnectTimeout != "" {
+		ssh.ConnectTimeout = merge.ConnectTimeout
+	}
+}
@@ -0,0 +1,30 @@
+package upcloud
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUpcloudBuilderSettingsMergeLists(t *testing.T) {
+	settings := UpcloudBuilderSettings{}
+	settings.Merge(UpcloudBuilderSettings{
+		Tags:     []string{"project", "web"},
+		Hosts:    []string{"00000000-0000-0000-0000-000000000001"},
+		Zones:    []string{"fi-hel1"},
+		Storages: []string{"01000000-0000-0000-0000-000000000001"},
+	})
+	settings.Merge(UpcloudBuilderSettings{
+		Tags:  []string{"web", "db"},
+		Zones: []string{"fi-hel1", "de-fra1"},
+	})
+
+	if want := []string{"project", "web", "db"}; !reflect.DeepEqual(settings.Tags, want) {
+		t.Errorf("merged tags are %v, want %v", settings.Tags, want)
+	}
+	if want := []string{"fi-hel1", "de-fra1"}; !reflect.DeepEqual(settings.Zones, want) {
+		t.Errorf("merged zones are %v, want %v", settings.Zones, want)
+	}
+	if len(settings.Hosts) != 1 || len(settings.Storages) != 1 {
+		t.Errorf("merged hosts %v and storages %v should be kept", settings.Hosts, settings.Storages)
+	}
+}
@@ -0,0 +1,218 @@
+package upcloud
+
+import (
+	"errors"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+
+	upcloud "github.com/Jalle19/upcloud-go-sdk/upcloud"
+
+	api_operation "github.com/wunderkraut/radi-api/operation"
+	api_property "github.com/wunderkraut/radi-api/property"
+	api_result "github.com/wunderkraut/radi-api/result"
+	api_usage "github.com/wunderkraut/radi-api/usage"
+)
+
+/**
+ * Config handler for Upcloud operations
+ *
+ * Publishes values about the provisioned project, so that other radi
+ * handlers can use them instead of having them copied by hand.
+ */
+type UpcloudConfigHandler struct {
+	BaseUpcloudServiceHandler
+}
+
+// Return a string identifier for the Handler (not functionally needed yet)
+func (config *UpcloudConfigHandler) Id() string {
+	return "upcloud.config"
+}
+
+// Initialize and activate the Handler
+func (config *UpcloudConfigHandler) Operations() api_operation.Operations {
+	ops := api_operation.New_SimpleOperations()
+
+	baseOperation := config.BaseUpcloudServiceOperation()
+
+	ops.Add(api_operation.Operation(&UpcloudConfigOutputsOperation{BaseUpcloudServiceOperation: *baseOperation}))
+
+	// importing retitles servers, so it is left out if UpCloud access is read only
+	if config.ReadOnly() {
+		log.Info("UpCloud access is read only, skipping the config import operation")
+	} else {
+		ops.Add(api_operation.Operation(&UpcloudConfigImportOperation{BaseUpcloudServiceOperation: *baseOperation}))
+	}
+
+	return ops.Operations()
+}
+
+/**
+ * Provisioned server outputs
+ *
+ * The outputs are a tree of values, with one entry per project server:
+ *
+ *   servers:
+ *     web:
+ *       id, uuid, title, hostname, state, zone,
+ *       ipv4, ipv4_private, ipv6, created
+ *
+ * A single value is read with a dot separated key, such as
+ * servers.web.ipv4, which is returned in the value property.
+ */
+type UpcloudConfigOutputsOperation struct {
+	BaseUpcloudServiceOperation
+}
+
+// Return the string machinename/id of the Operation
+func (outputs *UpcloudConfigOutputsOperation) Id() string {
+	return "upcloud.config.outputs"
+}
+
+// Return a user readable string label for the Operation
+func (outputs *UpcloudConfigOutputsOperation) Label() string {
+	return "UpCloud outputs"
+}
+
+// return a multiline string description for the Operation
+func (outputs *UpcloudConfigOutputsOperation) Description() string {
+	return "Provide the connection details of the provisioned servers, for other handlers."
+}
+
+// return a multiline string man page for the Operation
+func (outputs *UpcloudConfigOutputsOperation) Help() string {
+	return "Without a key all of the outputs are given as YAML.  With a key, such as servers.web.ipv4, only that value is given.  The value is also set on the value property, for other operations to use."
+}
+
+// Is this operation meant to be used only inside the API
+func (outputs *UpcloudConfigOutputsOperation) Usage() api_usage.Usage {
+	return api_operation.Usage_External()
+}
+
+// Run a validation check on the Operation
+func (outputs *UpcloudConfigOutputsOperation) Validate() api_result.Result {
+	return api_result.MakeSuccessfulResult()
+}
+
+// What settings/values does the Operation provide to an implemenentor
+func (outputs *UpcloudConfigOutputsOperation) Properties() api_property.Properties {
+	props := api_property.New_SimplePropertiesEmpty()
+
+	props.Add(api_property.Property(&UpcloudKeyProperty{}))
+	props.Add(api_property.Property(&UpcloudOutputProperty{}))
+	props.Add(api_property.Property(&UpcloudValueProperty{}))
+
+	return props.Properties()
+}
+
+// Execute the Operation
+func (outputs *UpcloudConfigOutputsOperation) Exec(props api_property.Properties) api_result.Result {
+	res := api_result.New_StandardResult()
+
+	key := ""
+	if keyProp, found := props.Get(UPCLOUD_KEY_PROPERTY); found {
+		key = keyProp.Get().(string)
+	}
+	output := ""
+	if outputProp, found := props.Get(UPCLOUD_OUTPUT_PROPERTY); found {
+		output = outputProp.Get().(string)
+	}
+
+	values, errs := buildOutputs(outputs.ServerDefinitions())
+	if len(errs) > 0 {
+		res.AddErrors(errs)
+		res.MarkFailed()
+	}
+
+	value, err := values.Value(key)
+	if err != nil {
+		res.AddError(err)
+		res.MarkFailed()
+		res.MarkFinished()
+		return res.Result()
+	}
+	log.WithFields(log.Fields{"key": key, "value": value}).Debug("UpCloud output")
+
+	if valueProp, found := props.Get(UPCLOUD_VALUE_PROPERTY); found {
+		valueProp.Set(value)
+	}
+	if !strings.HasSuffix(value, "\n") {
+		value += "\n"
+	}
+	if err := writeOutput(output, []byte(value)); err != nil {
+		res.AddError(err)
+		res.MarkFailed()
+	} else if len(errs) == 0 {
+		res.MarkSuccess()
+	}
+	res.MarkFinished()
+
+	return res.Result()
+}
+
+// A tree of output values
+type upcloudOutputs map[string]interface{}
+
+// Build the outputs for the project servers
+func buildOutputs(serverDefinitions *ServerDefinitions) (upcloudOutputs, []error) {
+	servers := map[string]interface{}{}
+	errs := []error{}
+
+	for _, id := range serverDefinitions.Order() {
+		serverDefinition, _ := serverDefinitions.Get(id)
+		server := map[string]interface{}{
+			"id":      id,
+			"created": false,
+		}
+		servers[id] = server
+
+		if !serverDefinition.IsCreated() {
+			continue
+		}
+		details, err := serverDefinition.GetServerDetails()
+		if err != nil {
+			errs = append(errs, err)
+			errs = append(errs, errors.New("Could not retrieve server details for the outputs : "+id))
+			continue
+		}
+
+		server["created"] = true
+		server["uuid"] = details.UUID
+		server["title"] = details.Title
+		server["hostname"] = details.Hostname
+		server["state"] = details.State
+		server["zone"] = details.Zone
+		server["ipv4"] = serverAddress(details, upcloud.IPAddressAccessPublic, upcloud.IPAddressFamilyIPv4)
+		server["ipv4_private"] = serverAddress(details, upcloud.IPAddressAccessPrivate, upcloud.IPAddressFamilyIPv4)
+		server["ipv6"] = serverAddress(details, upcloud.IPAddressAccessPublic, upcloud.IPAddressFamilyIPv6)
+	}
+
+	return upcloudOutputs{"servers": servers}, errs
+}
+
+// Find the value for a dot separated key, as a string for leaf values and YAML for trees
+func (outputs upcloudOutputs) Value(key string) (string, error) {
+	var value interface{} = map[string]interface{}(outputs)
+	if key != "" {
+		for _, part := range strings.Split(key, ".") {
+			tree, ok := value.(map[string]interface{})
+			if !ok {
+				return "", errors.New("UpCloud output key goes past a value : " + key)
+			}
+			if value, ok = tree[part]; !ok {
+				return "", errors.New("Unknown UpCloud output key : " + key)
+			}
+		}
+	}
+
+	switch leaf := value.(type) {
+	case string:
+		return leaf, nil
+	case map[string]interface{}:
+		source, err := yaml.Marshal(leaf)
+		return string(source), err
+	}
+	source, err := yaml.Marshal(value)
+	return strings.TrimSpace(string(source)), err
+}
@@ -0,0 +1,373 @@
+package upcloud
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+
+	upcloud "github.com/Jalle19/upcloud-go-sdk/upcloud"
+	upcloud_request "github.com/Jalle19/upcloud-go-sdk/upcloud/request"
+
+	api_operation "github.com/wunderkraut/radi-api/operation"
+	api_property "github.com/wunderkraut/radi-api/property"
+	api_result "github.com/wunderkraut/radi-api/result"
+	api_usage "github.com/wunderkraut/radi-api/usage"
+)
+
+/**
+ * Import of existing UpCloud servers into the project
+ */
+
+// Server import operation
+type UpcloudConfigImportOperation struct {
+	BaseUpcloudServiceOperation
+}
+
+// Return the string machinename/id of the Operation
+func (importOp *UpcloudConfigImportOperation) Id() string {
+	return "upcloud.config.import"
+}
+
+// Return a user readable string label for the Operation
+func (importOp *UpcloudConfigImportOperation) Label() string {
+	return "Import UpCloud servers"
+}
+
+// return a multiline string description for the Operation
+func (importOp *UpcloudConfigImportOperation) Description() string {
+	return "Generate upcloud.yml server definitions for existing UpCloud servers, and bring them into the project."
+}
+
+// return a multiline string man page for the Operation
+func (importOp *UpcloudConfigImportOperation) Help() string {
+	return `The server definitions are written as YAML, to add to the Servers of
+the upcloud.yml.  Storages are attached by UUID, as they already exist.
+
+Server ids can be given in the same order as the UUIDs, otherwise they
+are made from the server hostnames.  Each server is retitled with its
+project id, and recorded in the local state, so that it is found by the
+project.  In a dry run the servers are left as they are.
+
+A server titled as a server of a radi project ("KRAUT:<id>:...") may
+belong to another project, so it is only imported if its UUID is
+confirmed.`
+}
+
+// Is this operation meant to be used only inside the API
+func (importOp *UpcloudConfigImportOperation) Usage() api_usage.Usage {
+	return api_operation.Usage_External()
+}
+
+// Run a validation check on the Operation
+func (importOp *UpcloudConfigImportOperation) Validate() api_result.Result {
+	return api_result.MakeSuccessfulResult()
+}
+
+// What settings/values does the Operation provide to an implemenentor
+func (importOp *UpcloudConfigImportOperation) Properties() api_property.Properties {
+	props := api_property.New_SimplePropertiesEmpty()
+
+	props.Add(api_property.Property(&UpcloudServerUUIDSProperty{}))
+	props.Add(api_property.Property(&UpcloudServerIdsProperty{}))
+	props.Add(api_property.Property(&UpcloudOutputProperty{}))
+	props.Add(api_property.Property(&UpcloudDryRunProperty{}))
+	props.Add(api_property.Property(&UpcloudConfirmProperty{}))
+
+	return props.Properties()
+}
+
+// Execute the Operation
+func (importOp *UpcloudConfigImportOperation) Exec(props api_property.Properties) api_result.Result {
+	res := api_result.New_StandardResult()
+
+	service := importOp.ServiceWrapper()
+	serverDefinitions := importOp.ServerDefinitions()
+	state := importOp.State()
+
+	uuids := []string{}
+	if uuidsProp, found := props.Get(UPCLOUD_SERVER_UUIDS_PROPERTY); found {
+		uuids = uuidsProp.Get().([]string)
+	}
+	ids := []string{}
+	if idsProp, found := props.Get(UPCLOUD_SERVER_IDS_PROPERTY); found {
+		ids = idsProp.Get().([]string)
+	}
+	output := ""
+	if outputProp, found := props.Get(UPCLOUD_OUTPUT_PROPERTY); found {
+		output = outputProp.Get().(string)
+	}
+	dryRun := false
+	if dryRunProp, found := props.Get(UPCLOUD_DRYRUN_PROPERTY); found {
+		dryRun = dryRunProp.Get().(bool)
+	}
+
+	if len(uuids) == 0 {
+		res.AddError(errors.New("No server UUIDs were given to import."))
+		res.MarkFailed()
+		res.MarkFinished()
+		return res.Result()
+	}
+	if len(ids) > 0 && len(ids) != len(uuids) {
+		res.AddError(errors.New("When server ids are given, there must be one for each server UUID."))
+		res.MarkFailed()
+		res.MarkFinished()
+		return res.Result()
+	}
+
+	// the servers are named explicitly, so they are imported even if not yet in the project scope
+	service.SetGlobal(true)
+
+	failed := false
+	imported := []importServer{}
+	used := map[string]bool{}
+	for _, id := range serverDefinitions.Order() {
+		used[id] = true
+	}
+
+	for index, uuid := range uuids {
+		details, err := service.GetServerDetails(&upcloud_request.GetServerDetailsRequest{UUID: uuid})
+		if err != nil {
+			res.AddError(err)
+			res.AddError(errors.New("Could not retrieve server details to import : " + uuid))
+			failed = true
+			continue
+		}
+
+		// taking a server from another project would leave that project without it
+		if owner := importServerOwner(details.Title); owner != "" && !protectionConfirmed(props, uuid) {
+			log.WithFields(log.Fields{"UUID": uuid, "title": details.Title, "project server": owner}).Warn("IMPORT: Server belongs to a radi project, confirm its UUID to import it")
+			res.AddError(errors.New("Server is titled as the server " + owner + " of a radi project, so it was not imported unless confirmed : " + uuid))
+			failed = true
+			continue
+		}
+
+		id := ""
+		if len(ids) > 0 {
+			id = ids[index]
+		} else {
+			id = importServerId(details)
+		}
+		if used[id] {
+			res.AddError(errors.New("A server with this id is already in the project, so " + uuid + " was not imported : " + id))
+			failed = true
+			continue
+		}
+		used[id] = true
+
+		rules := upcloud.FirewallRules{}
+		if serverRules, err := service.GetFirewallRules(&upcloud_request.GetFirewallRulesRequest{ServerUUID: uuid}); err == nil {
+			rules = *serverRules
+		} else {
+			res.AddError(err)
+			res.AddError(errors.New("Could not retrieve firewall rules to import : " + uuid))
+			failed = true
+			continue
+		}
+
+		server, errs := newImportServer(service, id, details, rules)
+		if len(errs) > 0 {
+			res.AddErrors(errs)
+			failed = true
+			continue
+		}
+		imported = append(imported, server)
+
+		logger := log.WithFields(log.Fields{"id": id, "UUID": uuid, "title": details.Title})
+		if dryRun {
+			logger.Info("IMPORT: Dry run, server not retitled")
+			continue
+		}
+
+		title := "KRAUT:" + id + ":" + server.Title
+		if _, err := service.ModifyServer(&upcloud_request.ModifyServerRequest{UUID: uuid, Title: title}); err != nil {
+			res.AddError(err)
+			res.AddError(errors.New("Could not retitle imported server : " + uuid))
+			failed = true
+			continue
+		}
+		// the storages are attached in the definition, so none are recorded as created by the project
+		state.Set(id, New_UpcloudStateServer(*details, []string{}, rules))
+		logger.WithFields(log.Fields{"newtitle": title}).Info("IMPORT: Server imported")
+	}
+
+	if len(imported) > 0 {
+		source, err := yaml.Marshal(struct {
+			Servers []importServer `yaml:"Servers"`
+		}{Servers: imported})
+		if err == nil {
+			err = writeOutput(output, source)
+		}
+		if err != nil {
+			res.AddError(err)
+			res.AddError(errors.New("Could not write the imported server definitions."))
+			failed = true
+		}
+
+		if !dryRun {
+			if err := state.Save(); err != nil {
+				res.AddError(err)
+				res.AddError(errors.New("Could not save the UpCloud state file : " + state.Path()))
+				failed = true
+			}
+		}
+	}
+
+	if failed {
+		res.MarkFailed()
+	} else {
+		res.MarkSuccess()
+	}
+	res.MarkFinished()
+
+	return res.Result()
+}
+
+// Characters which are not used in generated server ids
+var importServerIdInvalid = regexp.MustCompile("[^a-z0-9-]+")
+
+// Make a project server id from a server hostname, or title
+func importServerId(details *upcloud.ServerDetails) string {
+	name := details.Hostname
+	if name == "" {
+		name = details.Title
+	}
+	if dot := strings.Index(name, "."); dot > 0 {
+		name = name[:dot]
+	}
+	name = strings.Trim(importServerIdInvalid.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if name == "" {
+		name = details.UUID
+	}
+	return name
+}
+
+// The server id in a radi project server title, "KRAUT:<id>:<title>", if it has one
+func importServerOwner(title string) string {
+	if !strings.HasPrefix(title, "KRAUT:") {
+		return ""
+	}
+	parts := strings.SplitN(title, ":", 3)
+	if len(parts) != 3 {
+		return ""
+	}
+	return parts[1]
+}
+
+// A server definition, as written to upcloud.yml
+type importServer struct {
+	Id           string                                        `yaml:"Id"`
+	Zone         string                                        `yaml:"Zone"`
+	Plan         string                                        `yaml:"Plan,omitempty"`
+	Title        string                                        `yaml:"Title"`
+	Hostname     string                                        `yaml:"Hostname"`
+	CoreNumber   int                                           `yaml:"CoreNumber,omitempty"`
+	MemoryAmount int                                           `yaml:"Memory,omitempty"`
+	Networks     []Yml_UpcloudFactory_ServerDefinition_Network `yaml:"Networks,omitempty"`
+	Storage      []importStorage                               `yaml:"Storage"`
+	Firewall     *Yml_UpcloudFactory_ServerFirewall            `yaml:"Firewall,omitempty"`
+}
+
+// A storage device of a server definition, with its backup rule
+type importStorage struct {
+	Yml_UpcloudFactory_ServerDefinition_CreateStorage `yaml:",inline"`
+	Backup                                            *Yml_UpcloudFactory_ServerDefinition_Storage_BackupRule `yaml:"Backup,omitempty"`
+}
+
+// Convert existing server details and firewall rules into a server definition
+func newImportServer(service *UpcloudServiceWrapper, id string, details *upcloud.ServerDetails, rules upcloud.FirewallRules) (importServer, []error) {
+	errs := []error{}
+
+	title := details.Title
+	if importServerOwner(title) != "" {
+		// a confirmed server from another project keeps only its own title
+		title = strings.SplitN(title, ":", 3)[2]
+	}
+
+	server := importServer{
+		Id:       id,
+		Zone:     details.Zone,
+		Plan:     details.Plan,
+		Title:    title,
+		Hostname: details.Hostname,
+		Storage:  []importStorage{},
+	}
+	// custom plans define their own cores and memory
+	if details.Plan == "" || details.Plan == "custom" {
+		server.Plan = ""
+		server.CoreNumber = details.CoreNumber
+		server.MemoryAmount = details.MemoryAmount
+	}
+
+	seen := map[string]bool{}
+	for _, ip := range details.IPAddresses {
+		network := ip.Access + "/" + ip.Family
+		if seen[network] {
+			continue
+		}
+		seen[network] = true
+		server.Networks = append(server.Networks, Yml_UpcloudFactory_ServerDefinition_Network{Access: ip.Access, Family: ip.Family})
+	}
+
+	for _, device := range details.StorageDevices {
+		storage := importStorage{
+			Yml_UpcloudFactory_ServerDefinition_CreateStorage: Yml_UpcloudFactory_ServerDefinition_CreateStorage{
+				Action:  "attach",
+				Address: device.Address,
+				Storage: device.UUID,
+				Title:   device.Title,
+				Size:    device.Size,
+				Type:    device.Type,
+			},
+		}
+		if storageDetails, err := service.GetStorageDetails(&upcloud_request.GetStorageDetailsRequest{UUID: device.UUID}); err == nil {
+			storage.Tier = storageDetails.Tier
+			if rule := storageDetails.BackupRule; rule != nil && rule.Interval != "" {
+				storage.Backup = &Yml_UpcloudFactory_ServerDefinition_Storage_BackupRule{
+					Interval:  rule.Interval,
+					Time:      rule.Time,
+					Retention: rule.Retention,
+				}
+			}
+		} else {
+			errs = append(errs, err)
+			errs = append(errs, errors.New("Could not retrieve storage details to import : "+device.UUID))
+		}
+		server.Storage = append(server.Storage, storage)
+	}
+
+	if len(rules.FirewallRules) > 0 {
+		firewall := Yml_UpcloudFactory_ServerFirewall{}
+		for _, rule := range rules.FirewallRules {
+			firewall.Rules = append(firewall.Rules, Yml_UpcloudFactory_ServerFirewall_Rule{
+				Action:                  rule.Action,
+				Comment:                 rule.Comment,
+				DestinationAddressStart: rule.DestinationAddressStart,
+				DestinationAddressEnd:   rule.DestinationAddressEnd,
+				DestinationPortStart:    importPort(rule.DestinationPortStart),
+				DestinationPortEnd:      importPort(rule.DestinationPortEnd),
+				Direction:               rule.Direction,
+				Family:                  rule.Family,
+				ICMPType:                rule.ICMPType,
+				Position:                rule.Position,
+				Protocol:                rule.Protocol,
+				SourceAddressStart:      rule.SourceAddressStart,
+				SourceAddressEnd:        rule.SourceAddressEnd,
+				SourcePortStart:         importPort(rule.SourcePortStart),
+				SourcePortEnd:           importPort(rule.SourcePortEnd),
+			})
+		}
+		server.Firewall = &firewall
+	}
+
+	return server, errs
+}
+
+// Convert a firewall rule port, which may be empty
+func importPort(port string) int {
+	value, _ := strconv.Atoi(port)
+	return value
+}
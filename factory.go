@@ -1,6 +1,9 @@
 package upcloud
 
 import (
+	"errors"
+	"strings"
+
 	upcloud "github.com/Jalle19/upcloud-go-sdk/upcloud"
 	upcloud_client "github.com/Jalle19/upcloud-go-sdk/upcloud/client"
 	upcloud_request "github.com/Jalle19/upcloud-go-sdk/upcloud/request"
@@ -15,16 +18,27 @@ const (
 type UpcloudFactory interface {
 	ServiceWrapper() *UpcloudServiceWrapper
 	ServerDefinitions() ServerDefinitions
+	ReplicaGroups() map[string]int
+	ReadOnly() bool
+	State() *UpcloudState
 }
 
 // Definition for a single UpCloud server
 type ServerDefinition interface {
 	Id() string
+	Group() string
+	Roles() []string
+	DependsOn() []string
 	UUID() (string, error)
 
 	CreateServerRequest() upcloud_request.CreateServerRequest
+	UserData() (string, error)
+	DryRunUserData() (string, error)
+	IdentityFile() string
 
 	GetFirewallRules() upcloud.FirewallRules
+	ReadinessProbes() []ReadinessProbe
+	PostCreateHooks() []ServerHook
 	GetStorageDefinitions() StorageDefinitions
 
 	GetServerDetails() (*upcloud.ServerDetails, error)
@@ -32,6 +46,9 @@ type ServerDefinition interface {
 
 	IsCreated() bool
 	IsRunning() bool
+	IsProtected() bool
+
+	Validate() []error
 }
 
 // An ordered list of server definitions
@@ -71,6 +88,88 @@ func (defs *ServerDefinitions) Order() []string {
 	return defs.order
 }
 
+/**
+ * Return the def keys ordered so that each server comes after the
+ * servers that it depends on, otherwise keeping the configured order.
+ *
+ * A dependency can be a server id, or a replica group id, which is a
+ * dependency on all of the replicas in the group.  An error is returned
+ * for unknown dependencies and dependency cycles.
+ */
+func (defs *ServerDefinitions) DependencyOrder() ([]string, error) {
+	defs.safe()
+
+	dependencies := map[string][]string{}
+	for _, id := range defs.order {
+		for _, dependency := range defs.defs[id].DependsOn() {
+			resolved := defs.resolveDependency(dependency)
+			if len(resolved) == 0 {
+				return nil, errors.New("Server " + id + " depends on an unknown server : " + dependency)
+			}
+			dependencies[id] = append(dependencies[id], resolved...)
+		}
+	}
+
+	ordered := []string{}
+	placed := map[string]bool{}
+	for len(ordered) < len(defs.order) {
+		progress := false
+		for _, id := range defs.order {
+			if placed[id] {
+				continue
+			}
+			ready := true
+			for _, dependency := range dependencies[id] {
+				if !placed[dependency] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				ordered = append(ordered, id)
+				placed[id] = true
+				progress = true
+			}
+		}
+		if !progress {
+			cycle := []string{}
+			for _, id := range defs.order {
+				if !placed[id] {
+					cycle = append(cycle, id)
+				}
+			}
+			return nil, errors.New("Dependency cycle between servers : " + strings.Join(cycle, ", "))
+		}
+	}
+	return ordered, nil
+}
+
+// The servers that a server depends on, resolving replica groups
+func (defs *ServerDefinitions) Dependencies(id string) []string {
+	defs.safe()
+	resolved := []string{}
+	if def, exists := defs.defs[id]; exists {
+		for _, dependency := range def.DependsOn() {
+			resolved = append(resolved, defs.resolveDependency(dependency)...)
+		}
+	}
+	return resolved
+}
+
+// Resolve a dependency, which is a server id or a replica group id, into server ids
+func (defs *ServerDefinitions) resolveDependency(dependency string) []string {
+	if _, exists := defs.defs[dependency]; exists {
+		return []string{dependency}
+	}
+	resolved := []string{}
+	for _, id := range defs.order {
+		if defs.defs[id].Group() == dependency {
+			resolved = append(resolved, id)
+		}
+	}
+	return resolved
+}
+
 type StorageDefinition interface {
 	Id() string
 	BackupRule() upcloud.BackupRule
@@ -124,14 +223,14 @@ func New_UpcloudServiceWrapperFactory(client upcloud_client.Client) *UpcloudServ
 	}
 }
 
-// Get an Upcloud service from these settings
-func (serviceFactory UpcloudServiceWrapperFactory) Service() *upcloud_service.Service {
+// Get an Upcloud service from these settings, which is only used inside a wrapper
+func (serviceFactory UpcloudServiceWrapperFactory) service() *upcloud_service.Service {
 	return New_UpcloudServiceFromClient(serviceFactory.client)
 }
 
 // Get an Upcloud service from these settings
 func (serviceFactory UpcloudServiceWrapperFactory) ServiceWrapper() *UpcloudServiceWrapper {
-	service := serviceFactory.Service()
+	service := serviceFactory.service()
 	return New_UpcloudServiceWrapper(*service)
 }
 
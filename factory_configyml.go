@@ -2,8 +2,10 @@ package upcloud
 
 import (
 	"errors"
+	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"gopkg.in/yaml.v2"
@@ -11,7 +13,6 @@ import (
 	upcloud "github.com/Jalle19/upcloud-go-sdk/upcloud"
 	upcloud_client "github.com/Jalle19/upcloud-go-sdk/upcloud/client"
 	upcloud_request "github.com/Jalle19/upcloud-go-sdk/upcloud/request"
-	upcloud_service "github.com/Jalle19/upcloud-go-sdk/upcloud/service"
 
 	api_config "github.com/wunderkraut/radi-api/operation/config"
 )
@@ -31,14 +32,22 @@ type UpcloudFactoryConfigWrapperYaml struct {
 
 	scope string
 
-	User    Yml_UpcloudFactory_User     `yaml:"Access"`
-	Servers []Yml_UpcloudFactory_Server `yaml:"Servers"`
+	// server list cache shared by all server definitions
+	inventory *UpcloudInventory
+	// local provisioning state
+	state *UpcloudState
+
+	User      Yml_UpcloudFactory_User     `yaml:"Access"`
+	Variables map[string]string           `yaml:"Variables"`
+	Servers   []Yml_UpcloudFactory_Server `yaml:"Servers"`
 }
 
 // Constructor for UpcloudFactoryConfigWrapperYaml
 func New_UpcloudFactoryConfigWrapperYaml(configWrapper api_config.ConfigWrapper) *UpcloudFactoryConfigWrapperYaml {
 	return &UpcloudFactoryConfigWrapperYaml{
 		configWrapper: configWrapper,
+		inventory:     New_UpcloudInventory(UPCLOUD_INVENTORY_CACHE_TTL),
+		state:         New_UpcloudState(UPCLOUD_STATE_FILE_DEFAULT),
 	}
 }
 
@@ -69,27 +78,68 @@ func (configFactory *UpcloudFactoryConfigWrapperYaml) Client() *upcloud_client.C
 }
 
 // Convert this YML struct into a Service
-func (configFactory *UpcloudFactoryConfigWrapperYaml) Service() *upcloud_service.Service {
+func (configFactory *UpcloudFactoryConfigWrapperYaml) ServiceWrapper() *UpcloudServiceWrapper {
 	client := configFactory.Client()
-	return New_UpcloudServiceWrapperFactory(*client).Service()
+	wrapper := New_UpcloudServiceWrapperFactory(*client).ServiceWrapper()
+	wrapper.SetInventory(configFactory.inventory)
+	if configFactory.ReadOnly() {
+		wrapper.SetReadOnly()
+	}
+	return wrapper
 }
 
-// Convert this YML struct into a Service
-func (configFactory *UpcloudFactoryConfigWrapperYaml) ServiceWrapper() *UpcloudServiceWrapper {
-	client := configFactory.Client()
-	return New_UpcloudServiceWrapperFactory(*client).ServiceWrapper()
+// Set the local provisioning state
+func (configFactory *UpcloudFactoryConfigWrapperYaml) SetState(state *UpcloudState) {
+	configFactory.state = state
+}
+
+// Get the local provisioning state
+func (configFactory *UpcloudFactoryConfigWrapperYaml) State() *UpcloudState {
+	return configFactory.state
+}
+
+// Are the access credentials read only?
+func (configFactory *UpcloudFactoryConfigWrapperYaml) ReadOnly() bool {
+	return configFactory.User.ReadOnly
 }
 
 // Retieve a slice of ServerDefinitions
+//
+// A server with a Count is expanded into that many replicas, or
+// the count that the group was last scaled to.
 func (configFactory *UpcloudFactoryConfigWrapperYaml) ServerDefinitions() ServerDefinitions {
 	defs := ServerDefinitions{}
-	for _, ymlServer := range configFactory.Servers {
+	for index := range configFactory.Servers {
+		ymlServer := &configFactory.Servers[index]
 		ymlServer.factory = configFactory
-		defs.Add(ymlServer.ServerDefinition())
+
+		if ymlServer.count == 0 {
+			defs.Add(ymlServer.ServerDefinition())
+			continue
+		}
+
+		count := ymlServer.count
+		if scaled, found := configFactory.state.Scale(ymlServer.id, ymlServer.count); found {
+			count = scaled
+		}
+		for replica := 1; replica <= count; replica++ {
+			defs.Add(ymlServer.Replica(replica).ServerDefinition())
+		}
 	}
 	return defs
 }
 
+// The configured Count of each replica group
+func (configFactory *UpcloudFactoryConfigWrapperYaml) ReplicaGroups() map[string]int {
+	groups := map[string]int{}
+	for _, ymlServer := range configFactory.Servers {
+		if ymlServer.count > 0 {
+			groups[ymlServer.id] = ymlServer.count
+		}
+	}
+	return groups
+}
+
 // Retrieve values by parsing bytes from the wrapper
 func (configFactory *UpcloudFactoryConfigWrapperYaml) Load() error {
 	log.Debug("Loading UpCloud config")
@@ -101,6 +151,7 @@ func (configFactory *UpcloudFactoryConfigWrapperYaml) Load() error {
 			// empty out this oobject
 			configFactory.scope = scope
 			configFactory.User = Yml_UpcloudFactory_User{}
+			configFactory.Variables = map[string]string{}
 			configFactory.Servers = []Yml_UpcloudFactory_Server{}
 
 			if err := yaml.Unmarshal(scopedSource, &configFactory); err == nil {
@@ -133,6 +184,8 @@ func (configFactory *UpcloudFactoryConfigWrapperYaml) Save() error {
 type Yml_UpcloudFactory_User struct {
 	User     string `yaml:"User"`
 	Password string `yaml:"Password"`
+	// Only allow read calls with these credentials
+	ReadOnly bool `yaml:"ReadOnly"`
 }
 
 // Is this struct populated?
@@ -149,21 +202,35 @@ func (ymlFactoryUser *Yml_UpcloudFactory_User) Client() *upcloud_client.Client {
 type Yml_UpcloudFactory_Server struct {
 	factory *UpcloudFactoryConfigWrapperYaml
 
-	id   string
-	zone string
-	plan string
+	id        string
+	zone      string
+	plan      string
+	protected bool
+	roles     []string
+	dependsOn []string
+
+	// replica groups
+	count   int
+	group   string
+	replica int
 
 	serverDefinition   Yml_UpcloudFactory_ServerDefinition
 	storageDefinitions []Yml_UpcloudFactory_ServerDefinition_Storage
 	firewallRules      Yml_UpcloudFactory_ServerFirewall
+	readiness          []Yml_UpcloudFactory_ServerProbe
+	postCreate         []Yml_UpcloudFactory_ServerHook
 }
 
 func (server *Yml_UpcloudFactory_Server) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	// MetaData unmarshall
 	metaHolder := struct {
-		Id   string `yaml:"Id"`
-		Zone string `yaml:"Zone"`
-		Plan string `yaml:"Plan"`
+		Id        string   `yaml:"Id"`
+		Zone      string   `yaml:"Zone"`
+		Plan      string   `yaml:"Plan"`
+		Protected bool     `yaml:"Protected"`
+		Count     int      `yaml:"Count"`
+		Roles     []string `yaml:"Roles"`
+		DependsOn []string `yaml:"DependsOn"`
 	}{}
 	if err := unmarshal(&metaHolder); err != nil {
 		return err
@@ -171,6 +238,10 @@ func (server *Yml_UpcloudFactory_Server) UnmarshalYAML(unmarshal func(interface{
 	server.id = metaHolder.Id
 	server.zone = metaHolder.Zone
 	server.plan = metaHolder.Plan
+	server.protected = metaHolder.Protected
+	server.count = metaHolder.Count
+	server.roles = metaHolder.Roles
+	server.dependsOn = metaHolder.DependsOn
 	// log.WithFields(log.Fields{"id": server.id, "zone": server.zone, "holder": metaHolder}).Info("UPCLOUD:FACTORY:YML:ID")
 
 	// Create Server Request unmarshall
@@ -201,6 +272,26 @@ func (server *Yml_UpcloudFactory_Server) UnmarshalYAML(unmarshal func(interface{
 		return err
 	}
 	server.firewallRules = firewallHolder.Firewall
+
+	// Readiness probes unmarshall
+	readinessHolder := struct {
+		Readiness []Yml_UpcloudFactory_ServerProbe `yaml:"Readiness"`
+	}{}
+	if err := unmarshal(&readinessHolder); err != nil {
+		log.Error("YML ERROR READINESS")
+		return err
+	}
+	server.readiness = readinessHolder.Readiness
+
+	// Post create hooks unmarshall
+	postCreateHolder := struct {
+		PostCreate []Yml_UpcloudFactory_ServerHook `yaml:"PostCreate"`
+	}{}
+	if err := unmarshal(&postCreateHolder); err != nil {
+		log.Error("YML ERROR POSTCREATE")
+		return err
+	}
+	server.postCreate = postCreateHolder.PostCreate
 	// log.WithFields(log.Fields{"rules": server.firewallRules, "holder": firewallHolder}).Info("UPCLOUD:FACTORY:YML:FIREWALL")
 
 	return nil
@@ -211,11 +302,49 @@ func (server *Yml_UpcloudFactory_Server) ServerDefinition() ServerDefinition {
 	return ServerDefinition(server)
 }
 
+// Make a numbered replica of this server, for a replica group
+func (server *Yml_UpcloudFactory_Server) Replica(replica int) *Yml_UpcloudFactory_Server {
+	replicaServer := *server
+	replicaServer.group = server.id
+	replicaServer.id = server.id + "-" + strconv.Itoa(replica)
+	replicaServer.replica = replica
+	return &replicaServer
+}
+
 // Internal ID for the server
 func (server *Yml_UpcloudFactory_Server) Id() string {
 	return server.id
 }
 
+// The private key to log in with, the first SSHKeyFiles public key without its .pub, if it exists
+func (server *Yml_UpcloudFactory_Server) IdentityFile() string {
+	for _, file := range server.serverDefinition.LoginUser.SSHKeyFiles {
+		if !strings.HasSuffix(file, ".pub") {
+			continue
+		}
+		identity := projectPath(strings.TrimSuffix(file, ".pub"))
+		if _, err := os.Stat(identity); err == nil {
+			return identity
+		}
+	}
+	return ""
+}
+
+// Roles of the server in the project, used to select servers
+func (server *Yml_UpcloudFactory_Server) Roles() []string {
+	return server.roles
+}
+
+// Internal IDs of the servers, or replica groups, that this server depends on
+func (server *Yml_UpcloudFactory_Server) DependsOn() []string {
+	return server.dependsOn
+}
+
+// Internal ID for the replica group of the server, if it is a replica
+func (server *Yml_UpcloudFactory_Server) Group() string {
+	return server.group
+}
+
 // Internal method for retrieving UpCloud Server details
 func (server *Yml_UpcloudFactory_Server) getServer() (*upcloud.Server, error) {
 	/**
@@ -224,17 +353,33 @@ func (server *Yml_UpcloudFactory_Server) getServer() (*upcloud.Server, error) {
 	 *  - it relies on matching titles, which is editable in the UC UI
 	 *
 	 * A better approach would be to tag the server
+	 *
+	 * The server list comes from the factory inventory cache, so
+	 * that looking up each server doesn't retrieve the list again.
+	 * A server recorded in the local state is matched by UUID
+	 * before falling back to matching titles.
 	 */
 
-	if servers, err := server.factory.Service().GetServers(); err != nil {
+	if servers, err := server.factory.inventory.Servers(server.factory.ServiceWrapper()); err != nil {
 		return nil, err
 	} else {
 		id := server.Id()
-		titlePrefix := "KRAUT:" + id
+		if recorded, found := server.factory.state.Get(id); found {
+			for index, ucServer := range servers.Servers {
+				if ucServer.UUID == recorded.UUID {
+					log.WithFields(log.Fields{"index": index, "uuid": ucServer.UUID, "id": id}).Debug("YMLServer: located server from local state")
+					found := servers.Servers[index]
+					return &found, nil
+				}
+			}
+			log.WithFields(log.Fields{"uuid": recorded.UUID, "id": id}).Warn("YMLServer: server in local state not found on UpCloud")
+		}
+		titlePrefix := "KRAUT:" + id + ":"
 		for index, ucServer := range servers.Servers {
 			if strings.HasPrefix(ucServer.Title, titlePrefix) {
 				log.WithFields(log.Fields{"index": index, "uc.Title": ucServer.Title, "uuid": ucServer.UUID, "id": id}).Debug("YMLServer: located server on Upcloud")
-				return &ucServer, nil
+				found := servers.Servers[index]
+				return &found, nil
 			}
 		}
 	}
@@ -244,7 +389,7 @@ func (server *Yml_UpcloudFactory_Server) getServer() (*upcloud.Server, error) {
 // Retrieve UpCloud Server details
 func (server *Yml_UpcloudFactory_Server) GetServerDetails() (*upcloud.ServerDetails, error) {
 	if uuid, err := server.UUID(); err == nil {
-		details, err2 := server.factory.Service().GetServerDetails(&upcloud_request.GetServerDetailsRequest{UUID: uuid})
+		details, err2 := server.factory.ServiceWrapper().GetServerDetails(&upcloud_request.GetServerDetailsRequest{UUID: uuid})
 		return details, err2
 	} else {
 		return nil, err
@@ -285,10 +430,52 @@ func (server *Yml_UpcloudFactory_Server) IsCreated() bool {
 	return err == nil
 }
 
+// Check the server configuration for problems that would stop it being created
+func (server *Yml_UpcloudFactory_Server) Validate() []error {
+	_, errs := server.serverDefinition.LoginUser.PublicKeys()
+
+	if server.serverDefinition.UserData != "" && server.serverDefinition.UserDataFile != "" {
+		errs = append(errs, errors.New("Server has both UserData and UserDataFile"))
+	} else if file := server.serverDefinition.UserDataFile; file != "" {
+		if _, err := os.Stat(projectPath(file)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for _, probe := range server.readiness {
+		if err := probe.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, hook := range server.PostCreateHooks() {
+		if err := hook.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errs
+}
+
+// Is the server protected from deletion?
+func (server *Yml_UpcloudFactory_Server) IsProtected() bool {
+	return server.protected
+}
+
 // Build an upcloud CreateServerReequest
 func (server *Yml_UpcloudFactory_Server) CreateServerRequest() upcloud_request.CreateServerRequest {
 	request := server.serverDefinition.CreateServerRequest()
 
+	// Replicas get indexed titles and hostnames
+	if server.replica > 0 {
+		index := strconv.Itoa(server.replica)
+		request.Title = request.Title + "-" + index
+		if dot := strings.Index(request.Hostname, "."); dot > 0 {
+			request.Hostname = request.Hostname[:dot] + "-" + index + request.Hostname[dot:]
+		} else {
+			request.Hostname = request.Hostname + "-" + index
+		}
+	}
+
 	// Use a specific title so that we can uniquely identify this server
 	request.Title = "KRAUT:" + server.id + ":" + request.Title
 
@@ -300,14 +487,34 @@ func (server *Yml_UpcloudFactory_Server) GetFirewallRules() upcloud.FirewallRule
 	return server.firewallRules.FirewallRules()
 }
 
+// Build the readiness probes for the server
+func (server *Yml_UpcloudFactory_Server) ReadinessProbes() []ReadinessProbe {
+	probes := []ReadinessProbe{}
+	for _, probe := range server.readiness {
+		probes = append(probes, probe.ReadinessProbe())
+	}
+	return probes
+}
+
+// Build the hooks to run over ssh after the server is created
+func (server *Yml_UpcloudFactory_Server) PostCreateHooks() []ServerHook {
+	hooks := []ServerHook{}
+	for _, hook := range server.postCreate {
+		hooks = append(hooks, ServerHook{Command: hook.Command, Script: hook.Script})
+	}
+	return hooks
+}
+
 // Build upcloud StorageDefinitions for the server
 func (server *Yml_UpcloudFactory_Server) GetStorageDefinitions() StorageDefinitions {
 	defs := StorageDefinitions{}
-	for index, def := range server.storageDefinitions {
+	for index := range server.storageDefinitions {
+		// copy the definition, so that each entry points to its own storage
+		def := server.storageDefinitions[index]
 		if def.id == "" {
 			def.id = strconv.Itoa(index)
-			defs.Add(def.StorageDefinition())
 		}
+		defs.Add(def.StorageDefinition())
 	}
 	return defs
 }
@@ -323,6 +530,76 @@ func (server *Yml_UpcloudFactory_Server) GetStorageDefinitions() StorageDefiniti
  *
  */
 
+// A holder for a server readiness probe from yaml
+type Yml_UpcloudFactory_ServerProbe struct {
+	Type     string `yaml:"Type"`
+	Port     int    `yaml:"Port,omitempty"`
+	Path     string `yaml:"Path,omitempty"`
+	Scheme   string `yaml:"Scheme,omitempty"`
+	Access   string `yaml:"Access,omitempty"`
+	Timeout  string `yaml:"Timeout,omitempty"`
+	Interval string `yaml:"Interval,omitempty"`
+	Retries  int    `yaml:"Retries,omitempty"`
+}
+
+// Get a ReadinessProbe, bad durations are left as the defaults
+func (probe *Yml_UpcloudFactory_ServerProbe) ReadinessProbe() ReadinessProbe {
+	readinessProbe := ReadinessProbe{
+		Type:    strings.ToLower(probe.Type),
+		Port:    probe.Port,
+		Path:    probe.Path,
+		Scheme:  probe.Scheme,
+		Access:  probe.Access,
+		Retries: probe.Retries,
+	}
+	if timeout, err := time.ParseDuration(probe.Timeout); err == nil {
+		readinessProbe.Timeout = timeout
+	}
+	if interval, err := time.ParseDuration(probe.Interval); err == nil {
+		readinessProbe.Interval = interval
+	}
+	return readinessProbe
+}
+
+// Check the probe durations, which ReadinessProbe() can't report
+func (probe *Yml_UpcloudFactory_ServerProbe) Validate() error {
+	for _, duration := range []string{probe.Timeout, probe.Interval} {
+		if duration == "" {
+			continue
+		}
+		if _, err := time.ParseDuration(duration); err != nil {
+			return err
+		}
+	}
+	readinessProbe := probe.ReadinessProbe()
+	return readinessProbe.Validate()
+}
+
+// A holder for a server hook from yaml, which can also be just a command string
+type Yml_UpcloudFactory_ServerHook struct {
+	Command string `yaml:"Command,omitempty"`
+	Script  string `yaml:"Script,omitempty"`
+}
+
+func (hook *Yml_UpcloudFactory_ServerHook) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	command := ""
+	if err := unmarshal(&command); err == nil {
+		hook.Command = command
+		return nil
+	}
+
+	holder := struct {
+		Command string `yaml:"Command"`
+		Script  string `yaml:"Script"`
+	}{}
+	if err := unmarshal(&holder); err != nil {
+		return err
+	}
+	hook.Command = holder.Command
+	hook.Script = holder.Script
+	return nil
+}
+
 // A holder for server firewall rules configuration from yaml
 type Yml_UpcloudFactory_ServerFirewall struct {
 	Rules []Yml_UpcloudFactory_ServerFirewall_Rule `yaml:"Rules"`
@@ -403,6 +680,7 @@ type Yml_UpcloudFactory_ServerDefinition struct {
 	TimeZone         string                                              `yaml:"Timezone,omitempty"`
 	Title            string                                              `yaml:"Title"`
 	UserData         string                                              `yaml:"UserData,omitempty"`
+	UserDataFile     string                                              `yaml:"UserDataFile,omitempty"`
 	VideoModel       string                                              `yaml:"VideoModel,omitempty"`
 	VNC              bool                                                `yaml:"Vnc,omitempty"`
 	VNCPassword      string                                              `yaml:"VncPassword,omitempty"`
@@ -431,10 +709,12 @@ func (server *Yml_UpcloudFactory_ServerDefinition) CreateServerRequest() upcloud
 		StorageDevices:   []upcloud.CreateServerStorageDevice{},
 	}
 
+	// only valid keys are passed, the invalid keys are reported by Validate()
+	sshKeys, _ := server.LoginUser.PublicKeys()
 	request.LoginUser = &upcloud_request.LoginUser{
 		CreatePassword: convertBoolToString(server.LoginUser.CreatePassword, "yesno"),
 		Username:       server.LoginUser.Username,
-		SSHKeys:        server.LoginUser.SSHKeys,
+		SSHKeys:        sshKeys,
 	}
 
 	if len(server.Networks) > 0 {
@@ -494,6 +774,52 @@ type Yml_UpcloudFactory_ServerDefinition_User struct {
 	CreatePassword bool     `yaml:"CreatePassword,omitempty"`
 	Username       string   `yaml:"Username,omitempty"`
 	SSHKeys        []string `yaml:"SSHKeys,omitempty"`
+	SSHKeyFiles    []string `yaml:"SSHKeyFiles,omitempty"`
+	SSHKeyDir      string   `yaml:"SSHKeyDir,omitempty"`
+}
+
+// Collect the SSH keys, from the keys, key files and key directory, returning the valid de-duplicated keys and any errors
+func (user *Yml_UpcloudFactory_ServerDefinition_User) PublicKeys() ([]string, []error) {
+	keys := []string{}
+	errs := []error{}
+	seen := map[string]bool{}
+
+	add := func(source string, key string) {
+		if err := validateSSHPublicKey(key); err != nil {
+			errs = append(errs, errors.New("Invalid SSH key in "+source+" : "+err.Error()))
+			return
+		}
+		identity := sshPublicKeyIdentity(key)
+		if !seen[identity] {
+			seen[identity] = true
+			keys = append(keys, key)
+		}
+	}
+
+	for index, key := range user.SSHKeys {
+		add("SSHKeys "+strconv.Itoa(index), key)
+	}
+
+	files := append([]string{}, user.SSHKeyFiles...)
+	if user.SSHKeyDir != "" {
+		if dirFiles, err := sshPublicKeyDirFiles(user.SSHKeyDir); err == nil {
+			files = append(files, dirFiles...)
+		} else {
+			errs = append(errs, err)
+		}
+	}
+	for _, file := range files {
+		fileKeys, err := readSSHPublicKeyFile(file)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, key := range fileKeys {
+			add(file, key)
+		}
+	}
+
+	return keys, errs
 }
 
 type Yml_UpcloudFactory_ServerDefinition_Network struct {
@@ -0,0 +1,65 @@
+package upcloud
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	upcloud "github.com/Jalle19/upcloud-go-sdk/upcloud"
+)
+
+const (
+	// How long a retrieved server list is trusted before it is retrieved again
+	UPCLOUD_INVENTORY_CACHE_TTL = 15 * time.Second
+)
+
+/**
+ * A cache of the UpCloud server list, shared by all server
+ * definitions from a factory, so that looking up a number of
+ * servers doesn't retrieve the full list once per lookup.
+ *
+ * The cache is invalidated by any mutating call made through
+ * a ServiceWrapper that uses it.
+ */
+type UpcloudInventory struct {
+	lock sync.Mutex
+
+	ttl     time.Duration
+	servers *upcloud.Servers
+	fetched time.Time
+}
+
+// Constructor for UpcloudInventory
+func New_UpcloudInventory(ttl time.Duration) *UpcloudInventory {
+	return &UpcloudInventory{
+		ttl: ttl,
+	}
+}
+
+// Retrieve the server list, from the cache if it is still fresh
+func (inventory *UpcloudInventory) Servers(service *UpcloudServiceWrapper) (*upcloud.Servers, error) {
+	inventory.lock.Lock()
+	defer inventory.lock.Unlock()
+
+	if inventory.servers != nil && time.Since(inventory.fetched) < inventory.ttl {
+		return inventory.servers, nil
+	}
+
+	servers, err := service.GetServers()
+	if err != nil {
+		return nil, err
+	}
+	log.WithFields(log.Fields{"servers": len(servers.Servers)}).Debug("UpCloud inventory: retrieved server list")
+	inventory.servers = servers
+	inventory.fetched = time.Now()
+	return servers, nil
+}
+
+// Drop the cached server list, so that the next request retrieves it again
+func (inventory *UpcloudInventory) Invalidate() {
+	inventory.lock.Lock()
+	defer inventory.lock.Unlock()
+
+	inventory.servers = nil
+}
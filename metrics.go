@@ -0,0 +1,370 @@
+package upcloud
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	upcloud "github.com/Jalle19/upcloud-go-sdk/upcloud"
+	upcloud_request "github.com/Jalle19/upcloud-go-sdk/upcloud/request"
+
+	api_operation "github.com/wunderkraut/radi-api/operation"
+	api_property "github.com/wunderkraut/radi-api/property"
+	api_result "github.com/wunderkraut/radi-api/result"
+	api_usage "github.com/wunderkraut/radi-api/usage"
+)
+
+const (
+	// Default address for the metrics endpoint
+	UPCLOUD_METRICS_LISTEN = "127.0.0.1:9732"
+	// Default time between refreshes of the metrics
+	UPCLOUD_METRICS_INTERVAL = 60 * time.Second
+	// Shortest allowed time between refreshes, to stay clear of API limits
+	UPCLOUD_METRICS_INTERVAL_MIN = 10 * time.Second
+)
+
+/**
+ * Prometheus metrics for the project
+ *
+ * Metrics are served in the Prometheus text exposition format on
+ * /metrics.  Server and account metrics are refreshed on a schedule,
+ * so scrapes don't call UpCloud, while the API call metrics are
+ * counted by the service wrapper as calls are made.
+ */
+
+// Metrics endpoint operation
+type UpcloudMonitorMetricsOperation struct {
+	BaseUpcloudServiceOperation
+
+	// closed to stop the operation, which otherwise stops when the process is interrupted
+	stop <-chan struct{}
+}
+
+// Set the channel that stops the operation when closed
+func (metrics *UpcloudMonitorMetricsOperation) SetStop(stop <-chan struct{}) {
+	metrics.stop = stop
+}
+
+// Return the string machinename/id of the Operation
+func (metrics *UpcloudMonitorMetricsOperation) Id() string {
+	return "upcloud.monitor.metrics"
+}
+
+// Return a user readable string label for the Operation
+func (metrics *UpcloudMonitorMetricsOperation) Label() string {
+	return "UpCloud Prometheus metrics"
+}
+
+// return a multiline string description for the Operation
+func (metrics *UpcloudMonitorMetricsOperation) Description() string {
+	return "Serve Prometheus metrics for the project servers, the account and UpCloud API calls."
+}
+
+// return a multiline string man page for the Operation
+func (metrics *UpcloudMonitorMetricsOperation) Help() string {
+	return "The metrics are served on http://" + UPCLOUD_METRICS_LISTEN + "/metrics by default, until the operation is interrupted, or the stop channel set by an API caller is closed."
+}
+
+// Is this operation meant to be used only inside the API
+func (metrics *UpcloudMonitorMetricsOperation) Usage() api_usage.Usage {
+	return api_operation.Usage_External()
+}
+
+// Run a validation check on the Operation
+func (metrics *UpcloudMonitorMetricsOperation) Validate() api_result.Result {
+	return api_result.MakeSuccessfulResult()
+}
+
+// What settings/values does the Operation provide to an implemenentor
+func (metrics *UpcloudMonitorMetricsOperation) Properties() api_property.Properties {
+	props := api_property.New_SimplePropertiesEmpty()
+
+	props.Add(api_property.Property(&UpcloudListenProperty{}))
+	props.Add(api_property.Property(&UpcloudIntervalProperty{}))
+
+	return props.Properties()
+}
+
+// Execute the Operation
+func (metrics *UpcloudMonitorMetricsOperation) Exec(props api_property.Properties) api_result.Result {
+	res := api_result.New_StandardResult()
+
+	listen := UPCLOUD_METRICS_LISTEN
+	if listenProp, found := props.Get(UPCLOUD_LISTEN_PROPERTY); found && listenProp.Get().(string) != "" {
+		listen = listenProp.Get().(string)
+	}
+	interval := UPCLOUD_METRICS_INTERVAL
+	if intervalProp, found := props.Get(UPCLOUD_INTERVAL_PROPERTY); found && intervalProp.Get().(string) != "" {
+		if value, err := time.ParseDuration(intervalProp.Get().(string)); err == nil {
+			interval = value
+		} else {
+			res.AddError(err)
+			res.AddError(errors.New("Invalid metrics interval : " + intervalProp.Get().(string)))
+			res.MarkFailed()
+			res.MarkFinished()
+			return res.Result()
+		}
+	}
+	if interval < UPCLOUD_METRICS_INTERVAL_MIN {
+		interval = UPCLOUD_METRICS_INTERVAL_MIN
+	}
+
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		res.AddError(err)
+		res.AddError(errors.New("Could not listen for metrics on : " + listen))
+		res.MarkFailed()
+		res.MarkFinished()
+		return res.Result()
+	}
+
+	collector := &metricsCollector{}
+	collector.Refresh(metrics.ServiceWrapper(), metrics.ServerDefinitions())
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", collector)
+	server := &http.Server{Handler: mux}
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(listener)
+	}()
+
+	stop := metrics.stop
+	if stop == nil {
+		interrupt, release := UpcloudInterruptStop()
+		defer release()
+		stop = interrupt
+	}
+
+	log.WithFields(log.Fields{"url": "http://" + listener.Addr().String() + "/metrics", "interval": interval}).Info("METRICS: Serving UpCloud metrics, interrupt to stop")
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			collector.Refresh(metrics.ServiceWrapper(), metrics.ServerDefinitions())
+		case err := <-served:
+			res.AddError(err)
+			res.AddError(errors.New("Metrics server stopped unexpectedly."))
+			res.MarkFailed()
+			res.MarkFinished()
+			return res.Result()
+		case <-stop:
+			server.Close()
+			log.Info("METRICS: Stopped")
+			res.MarkSuccess()
+			res.MarkFinished()
+			return res.Result()
+		}
+	}
+}
+
+// Collects the project metrics on refresh, and serves them to scrapes
+type metricsCollector struct {
+	lock       sync.Mutex
+	exposition []byte
+}
+
+// Serve the last refreshed metrics, and the current API call metrics
+func (collector *metricsCollector) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	collector.lock.Lock()
+	exposition := collector.exposition
+	collector.lock.Unlock()
+
+	writer.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writer.Write(exposition)
+	writer.Write(apiCallExposition())
+}
+
+// Retrieve the project servers and account, and build the metrics from them
+func (collector *metricsCollector) Refresh(service *UpcloudServiceWrapper, serverDefinitions *ServerDefinitions) {
+	exposition := metricsExposition{}
+	success := 1.0
+
+	created := exposition.Family("upcloud_server_created", "gauge", "Whether the project server exists on UpCloud.")
+	state := exposition.Family("upcloud_server_state", "gauge", "The UpCloud state of the project server, 1 for the current state.")
+	cores := exposition.Family("upcloud_server_cores", "gauge", "CPU cores of the project server.")
+	memory := exposition.Family("upcloud_server_memory_megabytes", "gauge", "Memory of the project server in MB.")
+	storage := exposition.Family("upcloud_server_storage_gigabytes", "gauge", "Total size of the storage devices of the project server in GB.")
+	firewall := exposition.Family("upcloud_server_firewall_rules", "gauge", "Number of firewall rules on the project server.")
+
+	for _, id := range serverDefinitions.Order() {
+		serverDefinition, _ := serverDefinitions.Get(id)
+		if !serverDefinition.IsCreated() {
+			created.Add(0, "id", id)
+			continue
+		}
+		details, err := serverDefinition.GetServerDetails()
+		if err != nil {
+			log.WithError(err).WithFields(log.Fields{"id": id}).Warn("METRICS: Could not retrieve server details")
+			success = 0
+			continue
+		}
+		labels := []string{"id", id, "uuid", details.UUID, "zone", details.Zone}
+
+		created.Add(1, labels...)
+		for _, each := range []string{upcloud.ServerStateStarted, upcloud.ServerStateStopped, upcloud.ServerStateMaintenance, upcloud.ServerStateError} {
+			value := 0.0
+			if details.State == each {
+				value = 1
+			}
+			state.Add(value, append(labels, "state", each)...)
+		}
+		cores.Add(float64(details.CoreNumber), labels...)
+		memory.Add(float64(details.MemoryAmount), labels...)
+		size := 0
+		for _, device := range details.StorageDevices {
+			size += device.Size
+		}
+		storage.Add(float64(size), labels...)
+
+		if rules, err := service.GetFirewallRules(&upcloud_request.GetFirewallRulesRequest{ServerUUID: details.UUID}); err == nil {
+			firewall.Add(float64(len(rules.FirewallRules)), labels...)
+		} else {
+			log.WithError(err).WithFields(log.Fields{"id": id}).Warn("METRICS: Could not retrieve firewall rules")
+			success = 0
+		}
+	}
+
+	if account, err := service.GetAccount(); err == nil {
+		exposition.Family("upcloud_account_credits", "gauge", "Remaining UpCloud account credits.").Add(account.Credits)
+	} else {
+		log.WithError(err).Warn("METRICS: Could not retrieve the account")
+		success = 0
+	}
+
+	exposition.Family("upcloud_refresh_success", "gauge", "Whether the last refresh of the metrics retrieved everything.").Add(success)
+	exposition.Family("upcloud_refresh_timestamp_seconds", "gauge", "When the metrics were last refreshed.").Add(float64(time.Now().Unix()))
+
+	collector.lock.Lock()
+	collector.exposition = exposition.Bytes()
+	collector.lock.Unlock()
+}
+
+// Metric families, in the Prometheus text exposition format
+type metricsExposition struct {
+	families []*metricsFamily
+}
+
+// A metric family, with its samples
+type metricsFamily struct {
+	name    string
+	kind    string
+	help    string
+	samples []string
+}
+
+// Add a metric family
+func (exposition *metricsExposition) Family(name string, kind string, help string) *metricsFamily {
+	family := &metricsFamily{name: name, kind: kind, help: help}
+	exposition.families = append(exposition.families, family)
+	return family
+}
+
+// Add a sample, with label name and value pairs
+func (family *metricsFamily) Add(value float64, labels ...string) {
+	family.AddNamed(family.name, value, labels...)
+}
+
+// Add a sample with its own name, such as the _sum of a summary
+func (family *metricsFamily) AddNamed(name string, value float64, labels ...string) {
+	pairs := []string{}
+	for index := 0; index+1 < len(labels); index += 2 {
+		pairs = append(pairs, labels[index]+"=\""+metricsLabelEscaper.Replace(labels[index+1])+"\"")
+	}
+	sample := name
+	if len(pairs) > 0 {
+		sample += "{" + strings.Join(pairs, ",") + "}"
+	}
+	family.samples = append(family.samples, sample+" "+strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+// Label values escape backslashes, quotes and new lines
+var metricsLabelEscaper = strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n")
+
+// The exposition text, leaving out families without samples
+func (exposition *metricsExposition) Bytes() []byte {
+	var text bytes.Buffer
+	for _, family := range exposition.families {
+		if len(family.samples) == 0 {
+			continue
+		}
+		text.WriteString("# HELP " + family.name + " " + family.help + "\n")
+		text.WriteString("# TYPE " + family.name + " " + family.kind + "\n")
+		for _, sample := range family.samples {
+			text.WriteString(sample + "\n")
+		}
+	}
+	return text.Bytes()
+}
+
+// Counts and timing of UpCloud API calls made in this process, by call
+var apiCallMetrics = struct {
+	sync.Mutex
+	calls map[string]*apiCallMetric
+}{calls: map[string]*apiCallMetric{}}
+
+// The counts and timing of one API call
+type apiCallMetric struct {
+	count   int
+	errors  int
+	seconds float64
+}
+
+// Make an UpCloud API call, recording it in the API call metrics
+func observeApiCallRun(call string, f func() error) error {
+	start := time.Now()
+	err := f()
+	observeApiCall(call, time.Since(start), err)
+	return err
+}
+
+// Record an UpCloud API call
+func observeApiCall(call string, duration time.Duration, err error) {
+	apiCallMetrics.Lock()
+	defer apiCallMetrics.Unlock()
+
+	metric, found := apiCallMetrics.calls[call]
+	if !found {
+		metric = &apiCallMetric{}
+		apiCallMetrics.calls[call] = metric
+	}
+	metric.count++
+	metric.seconds += duration.Seconds()
+	if err != nil {
+		metric.errors++
+	}
+}
+
+// The API call metrics, in the Prometheus text exposition format
+func apiCallExposition() []byte {
+	apiCallMetrics.Lock()
+	defer apiCallMetrics.Unlock()
+
+	calls := []string{}
+	for call := range apiCallMetrics.calls {
+		calls = append(calls, call)
+	}
+	sort.Strings(calls)
+
+	exposition := metricsExposition{}
+	duration := exposition.Family("upcloud_api_call_duration_seconds", "summary", "Time spent in UpCloud API calls.")
+	failures := exposition.Family("upcloud_api_call_errors_total", "counter", "Failed UpCloud API calls.")
+	for _, call := range calls {
+		metric := apiCallMetrics.calls[call]
+		duration.AddNamed("upcloud_api_call_duration_seconds_sum", metric.seconds, "call", call)
+		duration.AddNamed("upcloud_api_call_duration_seconds_count", float64(metric.count), "call", call)
+		failures.Add(float64(metric.errors), "call", call)
+	}
+	return exposition.Bytes()
+}
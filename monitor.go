@@ -36,6 +36,13 @@ func (monitor *UpcloudMonitorHandler) Operations() api_operation.Operations {
 	ops.Add(api_operation.Operation(&UpcloudMonitorListServersOperation{BaseUpcloudServiceOperation: *baseOperation}))
 	ops.Add(api_operation.Operation(&UpcloudMonitorServerDetailsOperation{BaseUpcloudServiceOperation: *baseOperation}))
 	ops.Add(api_operation.Operation(&UpcloudMonitorListStoragesOperation{BaseUpcloudServiceOperation: *baseOperation}))
+	ops.Add(api_operation.Operation(&UpcloudMonitorCostOperation{BaseUpcloudServiceOperation: *baseOperation}))
+	ops.Add(api_operation.Operation(&UpcloudMonitorOrphansOperation{BaseUpcloudServiceOperation: *baseOperation}))
+	ops.Add(api_operation.Operation(&UpcloudMonitorAnsibleInventoryOperation{BaseUpcloudServiceOperation: *baseOperation}))
+	ops.Add(api_operation.Operation(&UpcloudMonitorSSHConfigOperation{BaseUpcloudServiceOperation: *baseOperation}))
+	ops.Add(api_operation.Operation(&UpcloudMonitorStatusOperation{BaseUpcloudServiceOperation: *baseOperation}))
+	ops.Add(api_operation.Operation(&UpcloudMonitorWatchOperation{BaseUpcloudServiceOperation: *baseOperation}))
+	ops.Add(api_operation.Operation(&UpcloudMonitorMetricsOperation{BaseUpcloudServiceOperation: *baseOperation}))
 
 	return ops.Operations()
 }
@@ -0,0 +1,306 @@
+package upcloud
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	upcloud "github.com/Jalle19/upcloud-go-sdk/upcloud"
+	upcloud_request "github.com/Jalle19/upcloud-go-sdk/upcloud/request"
+
+	api_operation "github.com/wunderkraut/radi-api/operation"
+	api_property "github.com/wunderkraut/radi-api/property"
+	api_result "github.com/wunderkraut/radi-api/result"
+	api_usage "github.com/wunderkraut/radi-api/usage"
+)
+
+/**
+ * Cost estimation for the project servers, using the
+ * UpCloud price list.
+ *
+ * All amounts are in UpCloud credits (the same unit as the
+ * account credits).
+ */
+
+const (
+	// UpCloud stops billing a resource after this many hours in a month
+	UPCLOUD_BILLING_HOURS_PER_MONTH = 672
+	// How long a retrieved price list is kept before it is retrieved again
+	UPCLOUD_PRICES_CACHE_TTL = time.Hour
+)
+
+/**
+ * Monitor operation for project cost
+ */
+type UpcloudMonitorCostOperation struct {
+	BaseUpcloudServiceOperation
+}
+
+// Return the string machinename/id of the Operation
+func (cost *UpcloudMonitorCostOperation) Id() string {
+	return "upcloud.monitor.cost"
+}
+
+// Return a user readable string label for the Operation
+func (cost *UpcloudMonitorCostOperation) Label() string {
+	return "UpCloud cost estimate"
+}
+
+// return a multiline string description for the Operation
+func (cost *UpcloudMonitorCostOperation) Description() string {
+	return "Estimate the hourly and monthly cost of the UpCloud servers for this project."
+}
+
+// return a multiline string man page for the Operation
+func (cost *UpcloudMonitorCostOperation) Help() string {
+	return ""
+}
+
+// Is this operation meant to be used only inside the API
+func (cost *UpcloudMonitorCostOperation) Usage() api_usage.Usage {
+	return api_operation.Usage_External()
+}
+
+// Run a validation check on the Operation
+func (cost *UpcloudMonitorCostOperation) Validate() api_result.Result {
+	return api_result.MakeSuccessfulResult()
+}
+
+// What settings/values does the Operation provide to an implemenentor
+func (cost *UpcloudMonitorCostOperation) Properties() api_property.Properties {
+	return api_property.New_SimplePropertiesEmpty().Properties()
+}
+
+// Execute the Operation
+func (cost *UpcloudMonitorCostOperation) Exec(props api_property.Properties) api_result.Result {
+	res := api_result.New_StandardResult()
+
+	service := cost.ServiceWrapper()
+	settings := cost.BuilderSettings()
+	serverDefinitions := cost.ServerDefinitions()
+
+	estimate, err := estimateProjectCost(service, serverDefinitions)
+	if err != nil {
+		res.AddError(err)
+		res.AddError(errors.New("Could not estimate UpCloud project cost."))
+		res.MarkFailed()
+		res.MarkFinished()
+		return res.Result()
+	}
+
+	for _, server := range estimate.servers {
+		log.WithFields(log.Fields{"id": server.id, "zone": server.zone, "items": server.items, "hourly": formatCredits(server.hourly), "monthly": formatCredits(server.hourly * UPCLOUD_BILLING_HOURS_PER_MONTH)}).Info("Server cost estimate")
+	}
+	log.WithFields(log.Fields{"servers": len(estimate.servers), "hourly": formatCredits(estimate.Hourly()), "monthly": formatCredits(estimate.Monthly())}).Info("Project cost estimate")
+
+	if !settings.Budget.Empty() {
+		if err := settings.Budget.Check(estimate); err != nil {
+			log.WithError(err).Warn("Project cost estimate exceeds the budget")
+		} else {
+			log.WithFields(log.Fields{"hourly": formatCredits(settings.Budget.Hourly), "monthly": formatCredits(settings.Budget.Monthly)}).Info("Project cost estimate is within the budget")
+		}
+	}
+
+	res.MarkSuccess()
+	res.MarkFinished()
+
+	return res.Result()
+}
+
+// Cost estimate for a single server
+type serverCostEstimate struct {
+	id     string
+	zone   string
+	hourly float64
+	items  map[string]float64
+}
+
+// add an item to the estimate
+func (server *serverCostEstimate) add(item string, hourly float64) {
+	if server.items == nil {
+		server.items = map[string]float64{}
+	}
+	server.items[item] += hourly
+	server.hourly += hourly
+}
+
+// Cost estimate for all project servers
+type costEstimate struct {
+	servers []serverCostEstimate
+}
+
+// Total hourly cost
+func (estimate *costEstimate) Hourly() float64 {
+	total := 0.0
+	for _, server := range estimate.servers {
+		total += server.hourly
+	}
+	return total
+}
+
+// Total monthly cost
+func (estimate *costEstimate) Monthly() float64 {
+	return estimate.Hourly() * UPCLOUD_BILLING_HOURS_PER_MONTH
+}
+
+// Estimate the cost of all of the project server definitions
+func estimateProjectCost(service *UpcloudServiceWrapper, serverDefinitions *ServerDefinitions) (*costEstimate, error) {
+	priceZones, err := cachedPriceZones(service)
+	if err != nil {
+		return nil, err
+	}
+	plans, err := service.GetPlans()
+	if err != nil {
+		return nil, err
+	}
+
+	estimate := costEstimate{}
+	for _, id := range serverDefinitions.Order() {
+		serverDefinition, _ := serverDefinitions.Get(id)
+
+		server, err := estimateServerCost(id, serverDefinition.CreateServerRequest(), priceZones, plans)
+		if err != nil {
+			return nil, err
+		}
+		estimate.servers = append(estimate.servers, server)
+	}
+	return &estimate, nil
+}
+
+// Estimate the cost of a single server create request
+func estimateServerCost(id string, request upcloud_request.CreateServerRequest, priceZones *upcloud.PriceZones, plans *upcloud.Plans) (serverCostEstimate, error) {
+	server := serverCostEstimate{id: id, zone: request.Zone}
+
+	var prices *upcloud.PriceZone
+	for index, priceZone := range priceZones.PriceZones {
+		if priceZone.Name == request.Zone {
+			prices = &priceZones.PriceZones[index]
+			break
+		}
+	}
+	if prices == nil {
+		return server, errors.New("No UpCloud prices found for zone " + request.Zone + " of server " + id)
+	}
+
+	// storage and IP addresses that are included in a plan price
+	includedStorage := 0
+	includedIPv4 := 0
+
+	if request.Plan == "" || request.Plan == "custom" {
+		server.add("cores", priceFor(prices.ServerCore, float64(request.CoreNumber)))
+		server.add("memory", priceFor(prices.ServerMemory, float64(request.MemoryAmount)))
+	} else {
+		var plan *upcloud.Plan
+		for index, match := range plans.Plans {
+			if match.Name == request.Plan {
+				plan = &plans.Plans[index]
+				break
+			}
+		}
+		if plan == nil {
+			return server, errors.New("Plan " + request.Plan + " of server " + id + " is not offered")
+		}
+
+		if planPrice := priceForPlan(prices, plan.Name); planPrice != nil {
+			server.add("plan", priceFor(planPrice, 1))
+		} else {
+			log.WithFields(log.Fields{"id": id, "plan": plan.Name}).Warn("No price listed for plan, estimating from cores and memory")
+			server.add("plan", priceFor(prices.ServerCore, float64(plan.CoreNumber))+priceFor(prices.ServerMemory, float64(plan.MemoryAmount)))
+		}
+		includedStorage = plan.StorageSize
+		includedIPv4 = 1
+	}
+
+	for _, device := range request.StorageDevices {
+		size := device.Size
+		if includedStorage > 0 {
+			if size > includedStorage {
+				size -= includedStorage
+				includedStorage = 0
+			} else {
+				includedStorage -= size
+				size = 0
+			}
+		}
+		if device.Tier == upcloud.StorageTierMaxIOPS {
+			server.add("storage:"+upcloud.StorageTierMaxIOPS, priceFor(prices.StorageMaxIOPS, float64(size)))
+		} else {
+			server.add("storage:"+upcloud.StorageTierHDD, priceFor(prices.StorageHDD, float64(size)))
+		}
+	}
+
+	for _, address := range request.IPAddresses {
+		if address.Access != upcloud.IPAddressAccessPublic {
+			continue
+		}
+		if address.Family == upcloud.IPAddressFamilyIPv6 {
+			server.add("ipv6", priceFor(prices.IPv6Address, 1))
+		} else if includedIPv4 > 0 {
+			includedIPv4--
+		} else {
+			server.add("ipv4", priceFor(prices.IPv4Address, 1))
+		}
+	}
+
+	if request.Firewall == convertBoolToString(true, "onoff") {
+		server.add("firewall", priceFor(prices.Firewall, 1))
+	}
+
+	return server, nil
+}
+
+// Hourly price for a quantity of a priced item
+func priceFor(price *upcloud.Price, quantity float64) float64 {
+	if price == nil || price.Amount == 0 {
+		return 0
+	}
+	return price.Price * quantity / float64(price.Amount)
+}
+
+// Price of a plan, if the price list has one for it
+func priceForPlan(prices *upcloud.PriceZone, plan string) *upcloud.Price {
+	switch plan {
+	case "1xCPU-1GB":
+		return prices.ServerPlan1xCPU1GB
+	case "2xCPU-2GB":
+		return prices.ServerPlan2xCPU2GB
+	case "4xCPU-4GB":
+		return prices.ServerPlan4xCPU4GB
+	case "6xCPU-8GB":
+		return prices.ServerPlan6xCPU8GB
+	}
+	return nil
+}
+
+// format an amount of credits for output
+func formatCredits(credits float64) string {
+	return strconv.FormatFloat(credits, 'f', 2, 64)
+}
+
+// A shared cache of the UpCloud price list, which rarely changes
+var priceZonesCache = struct {
+	sync.Mutex
+	priceZones *upcloud.PriceZones
+	retrieved  time.Time
+}{}
+
+// Retrieve the UpCloud price list, using the cache if it is fresh
+func cachedPriceZones(service *UpcloudServiceWrapper) (*upcloud.PriceZones, error) {
+	priceZonesCache.Lock()
+	defer priceZonesCache.Unlock()
+
+	if priceZonesCache.priceZones != nil && time.Since(priceZonesCache.retrieved) < UPCLOUD_PRICES_CACHE_TTL {
+		return priceZonesCache.priceZones, nil
+	}
+
+	priceZones, err := service.GetPriceZones()
+	if err != nil {
+		return nil, err
+	}
+	priceZonesCache.priceZones = priceZones
+	priceZonesCache.retrieved = time.Now()
+	return priceZones, nil
+}
@@ -0,0 +1,216 @@
+package upcloud
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	log "github.com/Sirupsen/logrus"
+
+	upcloud "github.com/Jalle19/upcloud-go-sdk/upcloud"
+	upcloud_request "github.com/Jalle19/upcloud-go-sdk/upcloud/request"
+
+	api_operation "github.com/wunderkraut/radi-api/operation"
+	api_property "github.com/wunderkraut/radi-api/property"
+	api_result "github.com/wunderkraut/radi-api/result"
+	api_usage "github.com/wunderkraut/radi-api/usage"
+)
+
+/**
+ * Project status, comparing each server definition to its UpCloud server
+ */
+
+// Project status operation
+type UpcloudMonitorStatusOperation struct {
+	BaseUpcloudServiceOperation
+}
+
+// Return the string machinename/id of the Operation
+func (status *UpcloudMonitorStatusOperation) Id() string {
+	return "upcloud.monitor.status"
+}
+
+// Return a user readable string label for the Operation
+func (status *UpcloudMonitorStatusOperation) Label() string {
+	return "UpCloud project status"
+}
+
+// return a multiline string description for the Operation
+func (status *UpcloudMonitorStatusOperation) Description() string {
+	return "Show each project server, its UpCloud state, and any drift from the project configuration."
+}
+
+// return a multiline string man page for the Operation
+func (status *UpcloudMonitorStatusOperation) Help() string {
+	return `Drift is checked for the zone, plan, cores and memory, storage devices,
+firewall rule count and storage backup rules.  The operation fails if
+any server has drifted, so that it can be used in CI.  Servers which
+have not been created are listed, but are not drift.`
+}
+
+// Is this operation meant to be used only inside the API
+func (status *UpcloudMonitorStatusOperation) Usage() api_usage.Usage {
+	return api_operation.Usage_External()
+}
+
+// Run a validation check on the Operation
+func (status *UpcloudMonitorStatusOperation) Validate() api_result.Result {
+	return api_result.MakeSuccessfulResult()
+}
+
+// What settings/values does the Operation provide to an implemenentor
+func (status *UpcloudMonitorStatusOperation) Properties() api_property.Properties {
+	props := api_property.New_SimplePropertiesEmpty()
+
+	props.Add(api_property.Property(&UpcloudOutputProperty{}))
+
+	return props.Properties()
+}
+
+// Execute the Operation
+func (status *UpcloudMonitorStatusOperation) Exec(props api_property.Properties) api_result.Result {
+	res := api_result.New_StandardResult()
+
+	service := status.ServiceWrapper()
+	serverDefinitions := status.ServerDefinitions()
+
+	output := ""
+	if outputProp, found := props.Get(UPCLOUD_OUTPUT_PROPERTY); found {
+		output = outputProp.Get().(string)
+	}
+
+	var table bytes.Buffer
+	writer := tabwriter.NewWriter(&table, 0, 4, 2, ' ', 0)
+	writer.Write([]byte("ID\tCREATED\tSTATE\tUUID\tDRIFT\n"))
+
+	failed := false
+	drifted := 0
+	for _, id := range serverDefinitions.Order() {
+		serverDefinition, _ := serverDefinitions.Get(id)
+
+		created := "no"
+		state := "-"
+		uuid := "-"
+		drift := []string{}
+
+		if serverDefinition.IsCreated() {
+			created = "yes"
+			details, err := serverDefinition.GetServerDetails()
+			if err != nil {
+				res.AddError(err)
+				res.AddError(errors.New("Could not retrieve server details : " + id))
+				failed = true
+				state = "unknown"
+			} else {
+				state = details.State
+				uuid = details.UUID
+
+				var errs []error
+				drift, errs = serverDrift(service, serverDefinition, details)
+				if len(errs) > 0 {
+					res.AddErrors(errs)
+					failed = true
+				}
+			}
+		}
+
+		driftColumn := "-"
+		if len(drift) > 0 {
+			drifted++
+			driftColumn = strings.Join(drift, ", ")
+			log.WithFields(log.Fields{"id": id, "UUID": uuid, "drift": drift}).Warn("Server has drifted from the project configuration")
+			res.AddError(errors.New("Server has drifted from the project configuration : " + id))
+		}
+		writer.Write([]byte(id + "\t" + created + "\t" + state + "\t" + uuid + "\t" + driftColumn + "\n"))
+	}
+	writer.Flush()
+
+	if err := writeOutput(output, table.Bytes()); err != nil {
+		res.AddError(err)
+		failed = true
+	}
+
+	if failed || drifted > 0 {
+		res.MarkFailed()
+	} else {
+		res.MarkSuccess()
+	}
+	res.MarkFinished()
+
+	return res.Result()
+}
+
+// Compare a server definition to its server, returning a readable description of each difference
+func serverDrift(service *UpcloudServiceWrapper, serverDefinition ServerDefinition, details *upcloud.ServerDetails) ([]string, []error) {
+	drift := []string{}
+	errs := []error{}
+
+	request := serverDefinition.CreateServerRequest()
+	differs := func(name string, want string, have string) {
+		if want != "" && want != have {
+			drift = append(drift, name+" "+have+" (want "+want+")")
+		}
+	}
+
+	differs("zone", request.Zone, details.Zone)
+	differs("plan", request.Plan, details.Plan)
+	if request.CoreNumber > 0 {
+		differs("cores", strconv.Itoa(request.CoreNumber), strconv.Itoa(details.CoreNumber))
+	}
+	if request.MemoryAmount > 0 {
+		differs("memory", strconv.Itoa(request.MemoryAmount), strconv.Itoa(details.MemoryAmount))
+	}
+
+	// storage devices are compared in order, as they are defined
+	differs("storage devices", strconv.Itoa(len(request.StorageDevices)), strconv.Itoa(len(details.StorageDevices)))
+	storageDefinitions := serverDefinition.GetStorageDefinitions()
+	for index, device := range details.StorageDevices {
+		if index >= len(request.StorageDevices) {
+			break
+		}
+		position := strconv.Itoa(index)
+		if size := request.StorageDevices[index].Size; size > 0 {
+			differs("storage "+position+" size", strconv.Itoa(size), strconv.Itoa(device.Size))
+		}
+
+		storageDefinition, found := storageDefinitions.Get(position)
+		if !found {
+			continue
+		}
+		want := storageDefinition.BackupRule()
+		storageDetails, err := service.GetStorageDetails(&upcloud_request.GetStorageDetailsRequest{UUID: device.UUID})
+		if err != nil {
+			errs = append(errs, err)
+			errs = append(errs, errors.New("Could not retrieve storage details : "+device.UUID))
+			continue
+		}
+		have := upcloud.BackupRule{}
+		if storageDetails.BackupRule != nil {
+			have = *storageDetails.BackupRule
+		}
+		if want.Interval != "" && (want.Interval != have.Interval || want.Time != have.Time || want.Retention != have.Retention) {
+			drift = append(drift, "storage "+position+" backup "+backupRuleString(have)+" (want "+backupRuleString(want)+")")
+		}
+	}
+
+	rules, err := service.GetFirewallRules(&upcloud_request.GetFirewallRulesRequest{ServerUUID: details.UUID})
+	if err != nil {
+		errs = append(errs, err)
+		errs = append(errs, errors.New("Could not retrieve firewall rules : "+details.UUID))
+	} else {
+		want := serverDefinition.GetFirewallRules()
+		differs("firewall rules", strconv.Itoa(len(want.FirewallRules)), strconv.Itoa(len(rules.FirewallRules)))
+	}
+
+	return drift, errs
+}
+
+// A short readable backup rule
+func backupRuleString(rule upcloud.BackupRule) string {
+	if rule.Interval == "" {
+		return "none"
+	}
+	return rule.Interval + "@" + rule.Time + "/" + strconv.Itoa(rule.Retention)
+}
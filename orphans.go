@@ -0,0 +1,243 @@
+package upcloud
+
+import (
+	"errors"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+
+	upcloud "github.com/Jalle19/upcloud-go-sdk/upcloud"
+	upcloud_request "github.com/Jalle19/upcloud-go-sdk/upcloud/request"
+
+	api_operation "github.com/wunderkraut/radi-api/operation"
+	api_property "github.com/wunderkraut/radi-api/property"
+	api_result "github.com/wunderkraut/radi-api/result"
+	api_usage "github.com/wunderkraut/radi-api/usage"
+)
+
+/**
+ * Detection of UpCloud resources that were left behind by
+ * the project, but no longer belong to any server definition
+ */
+
+/**
+ * Orphaned UpCloud resources
+ *
+ * Only the servers and storages are proven to belong to the project,
+ * through the state or a project tag, and so can be removed.  The
+ * other listed resources may belong to anyone on the account, and
+ * are only reported.
+ */
+type orphanReport struct {
+	servers  []upcloud.Server
+	storages []upcloud.Storage
+
+	// servers with a "KRAUT:" title, which may belong to another project
+	titled []upcloud.Server
+	// IP addresses which are not attached to any server
+	ips []upcloud.IPAddress
+
+	// released storages from the state, which no longer exist
+	missing []string
+}
+
+// Is there anything orphaned?
+func (report *orphanReport) Empty() bool {
+	return len(report.servers) == 0 && len(report.storages) == 0 && len(report.titled) == 0 && len(report.ips) == 0
+}
+
+// Log the orphaned resources
+func (report *orphanReport) Log() {
+	for _, server := range report.servers {
+		log.WithFields(log.Fields{"UUID": server.UUID, "title": server.Title, "zone": server.Zone, "state": server.State, "tags": server.Tags}).Info("Orphaned server")
+	}
+	for _, storage := range report.storages {
+		log.WithFields(log.Fields{"UUID": storage.UUID, "title": storage.Title, "zone": storage.Zone, "size": storage.Size}).Info("Orphaned storage")
+	}
+	for _, server := range report.titled {
+		log.WithFields(log.Fields{"UUID": server.UUID, "title": server.Title, "zone": server.Zone, "state": server.State, "tags": server.Tags}).Info("Radi server which is not known to the project, it may belong to another project")
+	}
+	for _, ip := range report.ips {
+		log.WithFields(log.Fields{"address": ip.Address, "family": ip.Family, "access": ip.Access, "zone": ip.Zone}).Info("Unattached IP address")
+	}
+	if report.Empty() {
+		log.Info("No orphaned UpCloud resources found")
+	}
+}
+
+/**
+ * Find orphaned resources
+ *
+ *   - servers recorded in the state or with a project tag, which
+ *     are not the server of any server definition
+ *   - project storages (from the state or the Storages setting)
+ *     which are not attached to any server
+ *
+ * Other "KRAUT:" titled servers and unattached IP addresses are
+ * listed, as they may have been left behind, but they can't be
+ * told apart from the resources of other projects on the account.
+ */
+func findOrphans(service *UpcloudServiceWrapper, serverDefinitions *ServerDefinitions, state *UpcloudState, settings *UpcloudBuilderSettings) (*orphanReport, error) {
+	report := orphanReport{}
+
+	defined := map[string]bool{}
+	for _, id := range serverDefinitions.Order() {
+		serverDefinition, _ := serverDefinitions.Get(id)
+		if uuid, err := serverDefinition.UUID(); err == nil {
+			defined[uuid] = true
+		}
+	}
+	recorded := map[string]bool{}
+	for _, id := range state.Ids() {
+		server, _ := state.Get(id)
+		recorded[server.UUID] = true
+	}
+
+	servers, err := service.GetServers()
+	if err != nil {
+		return nil, err
+	}
+	report.addServers(servers.Servers, defined, recorded, settings.Tags)
+
+	// collect the storages that have belonged to project servers
+	candidates := []string{}
+	candidates = append(candidates, state.Released()...)
+	for _, id := range state.Ids() {
+		recorded, _ := state.Get(id)
+		candidates = append(candidates, recorded.Storages...)
+	}
+	candidates = append(candidates, settings.Storages...)
+
+	storages, err := service.GetStorages(&upcloud_request.GetStoragesRequest{Access: upcloud.StorageAccessPrivate})
+	if err != nil {
+		return nil, err
+	}
+	live := map[string]upcloud.Storage{}
+	for _, storage := range storages.Storages {
+		live[storage.UUID] = storage
+	}
+	checked := map[string]bool{}
+	for _, uuid := range candidates {
+		if checked[uuid] {
+			continue
+		}
+		checked[uuid] = true
+
+		storage, found := live[uuid]
+		if !found {
+			report.missing = append(report.missing, uuid)
+			continue
+		}
+		if details, err := service.GetStorageDetails(&upcloud_request.GetStorageDetailsRequest{UUID: uuid}); err != nil {
+			return nil, err
+		} else if len(details.ServerUUIDs) == 0 {
+			report.storages = append(report.storages, storage)
+		}
+	}
+
+	addresses, err := service.GetIPAddresses()
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range addresses.IPAddresses {
+		if ip.ServerUUID == "" {
+			report.ips = append(report.ips, ip)
+		}
+	}
+
+	return &report, nil
+}
+
+/**
+ * Sort the account servers into the report
+ *
+ * Servers of a server definition are not orphaned.  Servers in the
+ * state, or with a project tag, are orphans of the project, while
+ * other "KRAUT:" titled servers are only listed.
+ */
+func (report *orphanReport) addServers(servers []upcloud.Server, defined map[string]bool, recorded map[string]bool, tags []string) {
+	for _, server := range servers {
+		if defined[server.UUID] {
+			continue
+		}
+		if recorded[server.UUID] || hasProjectTag(server.Tags, tags) {
+			report.servers = append(report.servers, server)
+		} else if strings.HasPrefix(server.Title, "KRAUT:") {
+			report.titled = append(report.titled, server)
+		}
+	}
+}
+
+// Does a list of server tags include a project tag?
+func hasProjectTag(tags []string, projectTags []string) bool {
+	for _, tag := range tags {
+		for _, projectTag := range projectTags {
+			if tag == projectTag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Orphaned resources operation
+type UpcloudMonitorOrphansOperation struct {
+	BaseUpcloudServiceOperation
+}
+
+// Return the string machinename/id of the Operation
+func (orphans *UpcloudMonitorOrphansOperation) Id() string {
+	return "upcloud.monitor.orphans"
+}
+
+// Return a user readable string label for the Operation
+func (orphans *UpcloudMonitorOrphansOperation) Label() string {
+	return "UpCloud orphaned resources"
+}
+
+// return a multiline string description for the Operation
+func (orphans *UpcloudMonitorOrphansOperation) Description() string {
+	return "List UpCloud servers, storages and IP addresses that may have been left behind by the project."
+}
+
+// return a multiline string man page for the Operation
+func (orphans *UpcloudMonitorOrphansOperation) Help() string {
+	return ""
+}
+
+// Is this operation meant to be used only inside the API
+func (orphans *UpcloudMonitorOrphansOperation) Usage() api_usage.Usage {
+	return api_operation.Usage_External()
+}
+
+// Run a validation check on the Operation
+func (orphans *UpcloudMonitorOrphansOperation) Validate() api_result.Result {
+	return api_result.MakeSuccessfulResult()
+}
+
+// What settings/values does the Operation provide to an implemenentor
+func (orphans *UpcloudMonitorOrphansOperation) Properties() api_property.Properties {
+	return api_property.New_SimplePropertiesEmpty().Properties()
+}
+
+// Execute the Operation
+func (orphans *UpcloudMonitorOrphansOperation) Exec(props api_property.Properties) api_result.Result {
+	res := api_result.New_StandardResult()
+
+	service := orphans.ServiceWrapper()
+	settings := orphans.BuilderSettings()
+	serverDefinitions := orphans.ServerDefinitions()
+
+	if report, err := findOrphans(service, serverDefinitions, orphans.State(), settings); err == nil {
+		report.Log()
+		res.MarkSuccess()
+	} else {
+		res.AddError(err)
+		res.AddError(errors.New("Could not retrieve UpCloud resources to look for orphans."))
+		res.MarkFailed()
+	}
+
+	res.MarkFinished()
+
+	return res.Result()
+}
@@ -0,0 +1,31 @@
+package upcloud
+
+import (
+	"testing"
+
+	upcloud "github.com/Jalle19/upcloud-go-sdk/upcloud"
+)
+
+func TestOrphanReportTaggedServer(t *testing.T) {
+	// the project tags reach the operations through a merge of the provider settings
+	settings := UpcloudBuilderSettings{}
+	settings.Merge(UpcloudBuilderSettings{Tags: []string{"shop"}})
+
+	servers := []upcloud.Server{
+		{UUID: "00000000-0000-0000-0000-000000000001", Title: "KRAUT:web:shop", Tags: []string{"shop"}},
+		{UUID: "00000000-0000-0000-0000-000000000002", Title: "KRAUT:db:shop", Tags: []string{"shop"}},
+		{UUID: "00000000-0000-0000-0000-000000000003", Title: "KRAUT:web:blog"},
+		{UUID: "00000000-0000-0000-0000-000000000004", Title: "mail", Tags: []string{"mail"}},
+	}
+	defined := map[string]bool{"00000000-0000-0000-0000-000000000001": true}
+
+	report := orphanReport{}
+	report.addServers(servers, defined, map[string]bool{}, settings.Tags)
+
+	if len(report.servers) != 1 || report.servers[0].UUID != "00000000-0000-0000-0000-000000000002" {
+		t.Errorf("the tagged server missing from the state should be the only orphan, got %v", report.servers)
+	}
+	if len(report.titled) != 1 || report.titled[0].UUID != "00000000-0000-0000-0000-000000000003" {
+		t.Errorf("the untagged radi server should only be listed, got %v", report.titled)
+	}
+}
@@ -0,0 +1,45 @@
+package upcloud
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+/**
+ * Generated output, such as inventories, which is written either
+ * to stdout or to a file, so that it can be piped or saved.
+ */
+
+// Write generated output to a file path, or to stdout if the path is empty
+func writeOutput(path string, output []byte) error {
+	if path == "" {
+		_, err := os.Stdout.Write(output)
+		return err
+	}
+	if err := ioutil.WriteFile(path, output, 0644); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{"path": path, "bytes": len(output)}).Info("Output written")
+	return nil
+}
+
+// Open a stream of output, appending to a file path, or to stdout if the path is empty
+func openOutput(path string) (io.WriteCloser, error) {
+	if path == "" {
+		return nopWriteCloser{os.Stdout}, nil
+	}
+	return os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+}
+
+// A writer, such as stdout, which should not be closed when the output is done
+type nopWriteCloser struct {
+	io.Writer
+}
+
+// Leave the writer open
+func (nop nopWriteCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,211 @@
+package upcloud
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	upcloud "github.com/Jalle19/upcloud-go-sdk/upcloud"
+)
+
+const (
+	UPCLOUD_PROBE_TCP  = "tcp"
+	UPCLOUD_PROBE_SSH  = "ssh"
+	UPCLOUD_PROBE_HTTP = "http"
+
+	// Probe defaults
+	UPCLOUD_PROBE_TIMEOUT  = 5 * time.Second
+	UPCLOUD_PROBE_INTERVAL = 5 * time.Second
+	UPCLOUD_PROBE_RETRIES  = 12
+)
+
+/**
+ * Readiness probes, which check that a server is actually usable
+ * once UpCloud considers it started.
+ *
+ *   tcp   the port accepts connections
+ *   ssh   the port answers with an SSH banner
+ *   http  a GET of the path returns a 2xx status
+ */
+type ReadinessProbe struct {
+	Type   string
+	Port   int
+	Path   string
+	Scheme string
+	// which server address to probe, public (default) or private
+	Access string
+
+	Timeout  time.Duration
+	Interval time.Duration
+	Retries  int
+}
+
+// A short readable name for the probe
+func (probe ReadinessProbe) Name() string {
+	name := probe.Type + ":" + strconv.Itoa(probe.port())
+	if probe.Type == UPCLOUD_PROBE_HTTP {
+		name += probe.path()
+	}
+	return name
+}
+
+// The probed port, with defaults for ssh and http
+func (probe ReadinessProbe) port() int {
+	if probe.Port > 0 {
+		return probe.Port
+	}
+	switch probe.Type {
+	case UPCLOUD_PROBE_SSH:
+		return 22
+	case UPCLOUD_PROBE_HTTP:
+		if probe.Scheme == "https" {
+			return 443
+		}
+		return 80
+	}
+	return 0
+}
+
+// The probed http path
+func (probe ReadinessProbe) path() string {
+	if strings.HasPrefix(probe.Path, "/") {
+		return probe.Path
+	}
+	return "/" + probe.Path
+}
+
+// Check that the probe configuration can be used
+func (probe ReadinessProbe) Validate() error {
+	switch probe.Type {
+	case UPCLOUD_PROBE_TCP, UPCLOUD_PROBE_SSH, UPCLOUD_PROBE_HTTP:
+	default:
+		return errors.New("Unknown readiness probe type : " + probe.Type)
+	}
+	if probe.port() < 1 || probe.port() > 65535 {
+		return errors.New("Readiness probe needs a valid port : " + probe.Name())
+	}
+	if probe.Scheme != "" && probe.Scheme != "http" && probe.Scheme != "https" {
+		return errors.New("Readiness probe scheme should be http or https : " + probe.Name())
+	}
+	return nil
+}
+
+// Run the probe against a host, retrying until it passes, returning the number of attempts
+func (probe ReadinessProbe) Run(host string) (int, error) {
+	retries := probe.Retries
+	if retries < 1 {
+		retries = UPCLOUD_PROBE_RETRIES
+	}
+	interval := probe.Interval
+	if interval <= 0 {
+		interval = UPCLOUD_PROBE_INTERVAL
+	}
+
+	var err error
+	for attempt := 1; attempt <= retries; attempt++ {
+		if err = probe.Check(host); err == nil {
+			return attempt, nil
+		}
+		log.WithError(err).WithFields(log.Fields{"probe": probe.Name(), "host": host, "attempt": attempt}).Debug("Readiness probe not yet passing")
+		if attempt < retries {
+			time.Sleep(interval)
+		}
+	}
+	return retries, err
+}
+
+// Run the probe once against a host
+func (probe ReadinessProbe) Check(host string) error {
+	timeout := probe.Timeout
+	if timeout <= 0 {
+		timeout = UPCLOUD_PROBE_TIMEOUT
+	}
+	address := net.JoinHostPort(host, strconv.Itoa(probe.port()))
+
+	switch probe.Type {
+	case UPCLOUD_PROBE_TCP:
+		conn, err := net.DialTimeout("tcp", address, timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+
+	case UPCLOUD_PROBE_SSH:
+		conn, err := net.DialTimeout("tcp", address, timeout)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		banner, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(banner, "SSH-") {
+			return errors.New("No SSH banner from " + address)
+		}
+		return nil
+
+	case UPCLOUD_PROBE_HTTP:
+		scheme := probe.Scheme
+		if scheme == "" {
+			scheme = "http"
+		}
+		client := http.Client{Timeout: timeout}
+		response, err := client.Get(scheme + "://" + address + probe.path())
+		if err != nil {
+			return err
+		}
+		response.Body.Close()
+		if response.StatusCode < 200 || response.StatusCode > 299 {
+			return errors.New("HTTP status " + response.Status + " from " + address + probe.path())
+		}
+		return nil
+	}
+
+	return errors.New("Unknown readiness probe type : " + probe.Type)
+}
+
+// Find a server address, for an access (public/private) and family (IPv4/IPv6)
+func serverAddress(details *upcloud.ServerDetails, access string, family string) string {
+	for _, ip := range details.IPAddresses {
+		if ip.Access == access && ip.Family == family {
+			return ip.Address
+		}
+	}
+	return ""
+}
+
+// Run the readiness probes for a server, logging each result, and returning the errors of failed probes
+func runReadinessProbes(id string, details *upcloud.ServerDetails, probes []ReadinessProbe) []error {
+	errs := []error{}
+	for _, probe := range probes {
+		access := upcloud.IPAddressAccessPublic
+		if probe.Access == upcloud.IPAddressAccessPrivate {
+			access = upcloud.IPAddressAccessPrivate
+		}
+		host := serverAddress(details, access, upcloud.IPAddressFamilyIPv4)
+		logger := log.WithFields(log.Fields{"id": id, "UUID": details.UUID, "probe": probe.Name(), "host": host})
+		if host == "" {
+			logger.Error("FAIL: Readiness probe has no server address")
+			errs = append(errs, errors.New("Readiness probe "+probe.Name()+" has no "+access+" address for server : "+id))
+			continue
+		}
+
+		attempts, err := probe.Run(host)
+		if err != nil {
+			logger.WithError(err).WithFields(log.Fields{"attempts": attempts}).Error("FAIL: Readiness probe")
+			errs = append(errs, err)
+			errs = append(errs, errors.New("Readiness probe "+probe.Name()+" failed for server : "+id))
+		} else {
+			logger.WithFields(log.Fields{"attempts": attempts}).Info("PASS: Readiness probe")
+		}
+	}
+	return errs
+}
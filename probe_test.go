@@ -0,0 +1,212 @@
+package upcloud
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Listen on a local port, handling each connection in a goroutine
+func probeTestListener(t *testing.T, handle func(conn net.Conn)) (string, int) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go handle(conn)
+		}
+	}()
+
+	address := listener.Addr().(*net.TCPAddr)
+	return address.IP.String(), address.Port
+}
+
+// A local port that nothing is listening on
+func probeTestClosedPort(t *testing.T) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+	return port
+}
+
+// The host and port of a test http server
+func probeTestServer(t *testing.T, handler http.HandlerFunc) (string, int) {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	address, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, _ := strconv.Atoi(address.Port())
+	return address.Hostname(), port
+}
+
+func TestReadinessProbeCheckTCP(t *testing.T) {
+	host, port := probeTestListener(t, func(conn net.Conn) { conn.Close() })
+
+	probe := ReadinessProbe{Type: UPCLOUD_PROBE_TCP, Port: port, Timeout: time.Second}
+	if err := probe.Check(host); err != nil {
+		t.Errorf("tcp probe of a listening port failed: %s", err)
+	}
+
+	probe.Port = probeTestClosedPort(t)
+	if err := probe.Check(host); err == nil {
+		t.Error("tcp probe of a closed port passed")
+	}
+}
+
+func TestReadinessProbeCheckSSH(t *testing.T) {
+	probe := ReadinessProbe{Type: UPCLOUD_PROBE_SSH, Timeout: time.Second}
+
+	host, port := probeTestListener(t, func(conn net.Conn) {
+		defer conn.Close()
+		conn.Write([]byte("SSH-2.0-OpenSSH_9.6\r\n"))
+	})
+	probe.Port = port
+	if err := probe.Check(host); err != nil {
+		t.Errorf("ssh probe of a server with an SSH banner failed: %s", err)
+	}
+
+	host, port = probeTestListener(t, func(conn net.Conn) {
+		defer conn.Close()
+		conn.Write([]byte("220 smtp.example.com ESMTP\r\n"))
+	})
+	probe.Port = port
+	if err := probe.Check(host); err == nil {
+		t.Error("ssh probe of a server with another banner passed")
+	}
+}
+
+func TestReadinessProbeCheckSSHTimeout(t *testing.T) {
+	// accept, but never send a banner
+	release := make(chan bool)
+	t.Cleanup(func() { close(release) })
+	host, port := probeTestListener(t, func(conn net.Conn) {
+		defer conn.Close()
+		<-release
+	})
+
+	probe := ReadinessProbe{Type: UPCLOUD_PROBE_SSH, Port: port, Timeout: 100 * time.Millisecond}
+	start := time.Now()
+	err := probe.Check(host)
+	if err == nil {
+		t.Fatal("ssh probe of a server without a banner passed")
+	}
+	if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		t.Errorf("ssh probe without a banner should time out, got: %s", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("ssh probe did not respect its timeout, took %s", elapsed)
+	}
+}
+
+func TestReadinessProbeCheckHTTP(t *testing.T) {
+	requested := ""
+	host, port := probeTestServer(t, func(writer http.ResponseWriter, request *http.Request) {
+		requested = request.URL.Path
+		if request.URL.Path == "/health" {
+			writer.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writer.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	probe := ReadinessProbe{Type: UPCLOUD_PROBE_HTTP, Port: port, Path: "health", Timeout: time.Second}
+	if err := probe.Check(host); err != nil {
+		t.Errorf("http probe with a 2xx response failed: %s", err)
+	}
+	if requested != "/health" {
+		t.Errorf("http probe requested %q, want /health", requested)
+	}
+
+	probe.Path = "/"
+	if err := probe.Check(host); err == nil {
+		t.Error("http probe with a 503 response passed")
+	}
+}
+
+func TestReadinessProbeCheckHTTPTimeout(t *testing.T) {
+	release := make(chan bool)
+	host, port := probeTestServer(t, func(writer http.ResponseWriter, request *http.Request) {
+		<-release
+	})
+	// the server waits for open requests as it closes, so release them first
+	t.Cleanup(func() { close(release) })
+
+	probe := ReadinessProbe{Type: UPCLOUD_PROBE_HTTP, Port: port, Timeout: 100 * time.Millisecond}
+	start := time.Now()
+	if err := probe.Check(host); err == nil {
+		t.Fatal("http probe of a server that does not respond passed")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("http probe did not respect its timeout, took %s", elapsed)
+	}
+}
+
+func TestReadinessProbeRunRetries(t *testing.T) {
+	var requests int32
+	host, port := probeTestServer(t, func(writer http.ResponseWriter, request *http.Request) {
+		// the server becomes ready on the third request
+		if atomic.AddInt32(&requests, 1) < 3 {
+			writer.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		writer.WriteHeader(http.StatusOK)
+	})
+
+	probe := ReadinessProbe{Type: UPCLOUD_PROBE_HTTP, Port: port, Timeout: time.Second, Interval: 10 * time.Millisecond, Retries: 5}
+	attempts, err := probe.Run(host)
+	if err != nil {
+		t.Fatalf("http probe did not pass once the server was ready: %s", err)
+	}
+	if attempts != 3 {
+		t.Errorf("http probe passed after %d attempts, want 3", attempts)
+	}
+}
+
+func TestReadinessProbeRunExhausted(t *testing.T) {
+	var requests int32
+	host, port := probeTestServer(t, func(writer http.ResponseWriter, request *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		writer.WriteHeader(http.StatusInternalServerError)
+	})
+
+	probe := ReadinessProbe{Type: UPCLOUD_PROBE_HTTP, Port: port, Timeout: time.Second, Interval: 10 * time.Millisecond, Retries: 3}
+	attempts, err := probe.Run(host)
+	if err == nil {
+		t.Fatal("http probe passed against a failing server")
+	}
+	if attempts != 3 {
+		t.Errorf("http probe gave up after %d attempts, want 3", attempts)
+	}
+	if count := atomic.LoadInt32(&requests); count != 3 {
+		t.Errorf("http probe made %d requests, want 3", count)
+	}
+}
+
+func TestReadinessProbeRunTCPExhausted(t *testing.T) {
+	probe := ReadinessProbe{Type: UPCLOUD_PROBE_TCP, Port: probeTestClosedPort(t), Timeout: 100 * time.Millisecond, Interval: 10 * time.Millisecond, Retries: 2}
+	attempts, err := probe.Run("127.0.0.1")
+	if err == nil {
+		t.Fatal("tcp probe of a closed port passed")
+	}
+	if attempts != 2 {
+		t.Errorf("tcp probe gave up after %d attempts, want 2", attempts)
+	}
+}
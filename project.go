@@ -0,0 +1,46 @@
+package upcloud
+
+import (
+	"os"
+	"path/filepath"
+)
+
+/**
+ * Project relative paths
+ *
+ * Files named in the project configuration, and files that the
+ * handler keeps for the project, are relative to the project root,
+ * so that they resolve the same from any directory in the project.
+ */
+
+const (
+	// The folder that marks the root of a radi project
+	UPCLOUD_PROJECT_CONF_FOLDER = ".radi"
+)
+
+// The project root: the closest parent of the working directory with a .radi folder, or the working directory
+func projectRoot() string {
+	workingDir, err := os.Getwd()
+	if err != nil {
+		return "."
+	}
+	for dir := workingDir; ; {
+		if info, err := os.Stat(filepath.Join(dir, UPCLOUD_PROJECT_CONF_FOLDER)); err == nil && info.IsDir() {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return workingDir
+		}
+		dir = parent
+	}
+}
+
+// Resolve a path from the project configuration: ~/ paths are in the user home, relative paths are in the project root
+func projectPath(path string) string {
+	path = expandHomePath(path)
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(projectRoot(), path)
+}
@@ -18,6 +18,26 @@ const (
 	UPCLOUD_GLOBAL_PROPERTY               = "upcloud.global"
 	UPCLOUD_FORCE_PROPERTY                = "upcloud.force"
 	UPCLOUD_WAIT_PROPERTY                 = "upcloud.wait"
+	UPCLOUD_PREFLIGHT_PROPERTY            = "upcloud.preflight"
+	UPCLOUD_ROLLBACK_PROPERTY             = "upcloud.rollback"
+	UPCLOUD_KEEP_PROPERTY                 = "upcloud.keep"
+	UPCLOUD_TIMEOUT_PROPERTY              = "upcloud.timeout"
+	UPCLOUD_DRYRUN_PROPERTY               = "upcloud.dryrun"
+	UPCLOUD_CONFIRM_PROPERTY              = "upcloud.confirm"
+	UPCLOUD_GROUP_PROPERTY                = "upcloud.group"
+	UPCLOUD_COUNT_PROPERTY                = "upcloud.count"
+	UPCLOUD_COMMAND_PROPERTY              = "upcloud.command"
+	UPCLOUD_SERVER_IDS_PROPERTY           = "upcloud.server.ids"
+	UPCLOUD_TAG_PROPERTY                  = "upcloud.tag"
+	UPCLOUD_ROLE_PROPERTY                 = "upcloud.role"
+	UPCLOUD_FAILFAST_PROPERTY             = "upcloud.failfast"
+	UPCLOUD_FORMAT_PROPERTY               = "upcloud.format"
+	UPCLOUD_OUTPUT_PROPERTY               = "upcloud.output"
+	UPCLOUD_HOST_PROPERTY                 = "upcloud.host"
+	UPCLOUD_KEY_PROPERTY                  = "upcloud.key"
+	UPCLOUD_VALUE_PROPERTY                = "upcloud.value"
+	UPCLOUD_INTERVAL_PROPERTY             = "upcloud.interval"
+	UPCLOUD_LISTEN_PROPERTY               = "upcloud.listen"
 	UPCLOUD_FIREWALL_RULES_PROPERTY       = "upcloud.firewall.rules"
 	UPCLOUD_SERVER_UUID_PROPERTY          = "upcloud.server.uuid"
 	UPCLOUD_SERVER_UUIDS_PROPERTY         = "upcloud.server.uuids"
@@ -26,6 +46,7 @@ const (
 	UPCLOUD_STORAGE_UUID_PROPERTY         = "upcloud.storage.uuid"
 	UPCLOUD_STORAGE_UUIDS_PROPERTY        = "upcloud.storage.uuids"
 	UPCLOUD_ZONE_ID_PROPERTY              = "upcloud.zone.id"
+	UPCLOUD_ACCOUNT_PROPERTY              = "upcloud.account"
 )
 
 // A boolean flag that tells upcloud to consider services/zones outside the scope of the project
@@ -127,6 +148,166 @@ func (wait *UpcloudWaitProperty) Copy() api_property.Property {
 	return api_property.Property(prop)
 }
 
+// A boolean flag that tells provisioning to run the preflight checks before creating anything
+type UpcloudPreflightProperty struct {
+	api_property.BooleanProperty
+}
+
+// ID returns string unique property Identifier
+func (preflight *UpcloudPreflightProperty) Id() string {
+	return UPCLOUD_PREFLIGHT_PROPERTY
+}
+
+// Label returns a short user readable label for the property
+func (preflight *UpcloudPreflightProperty) Label() string {
+	return "Run UpCloud preflight checks"
+}
+
+// Description provides a longer multi-line string description of what the property does
+func (preflight *UpcloudPreflightProperty) Description() string {
+	return "Check the account, zones, plans, templates and SSH keys before provisioning"
+}
+
+// Mark a property as being for internal use only (no shown to users)
+func (preflight *UpcloudPreflightProperty) Usage() api_usage.Usage {
+	return api_property.Usage_Optional()
+}
+
+// Copy the property
+func (preflight *UpcloudPreflightProperty) Copy() api_property.Property {
+	prop := &UpcloudPreflightProperty{}
+	prop.Set(preflight.Get())
+	return api_property.Property(prop)
+}
+
+// A boolean flag that tells provisioning to remove anything it created, if it fails
+type UpcloudRollbackProperty struct {
+	api_property.BooleanProperty
+}
+
+// ID returns string unique property Identifier
+func (rollback *UpcloudRollbackProperty) Id() string {
+	return UPCLOUD_ROLLBACK_PROPERTY
+}
+
+// Label returns a short user readable label for the property
+func (rollback *UpcloudRollbackProperty) Label() string {
+	return "Roll back on failure"
+}
+
+// Description provides a longer multi-line string description of what the property does
+func (rollback *UpcloudRollbackProperty) Description() string {
+	return "Stop and delete any UpCloud servers created in this run if provisioning fails"
+}
+
+// Mark a property as being for internal use only (no shown to users)
+func (rollback *UpcloudRollbackProperty) Usage() api_usage.Usage {
+	return api_property.Usage_Optional()
+}
+
+// Copy the property
+func (rollback *UpcloudRollbackProperty) Copy() api_property.Property {
+	prop := &UpcloudRollbackProperty{}
+	prop.Set(rollback.Get())
+	return api_property.Property(prop)
+}
+
+// A boolean flag that tells provisioning to keep anything it created if it fails, for debugging
+type UpcloudKeepProperty struct {
+	api_property.BooleanProperty
+}
+
+// ID returns string unique property Identifier
+func (keep *UpcloudKeepProperty) Id() string {
+	return UPCLOUD_KEEP_PROPERTY
+}
+
+// Label returns a short user readable label for the property
+func (keep *UpcloudKeepProperty) Label() string {
+	return "Keep on failure"
+}
+
+// Description provides a longer multi-line string description of what the property does
+func (keep *UpcloudKeepProperty) Description() string {
+	return "Keep any UpCloud servers created in this run if provisioning fails, so that they can be debugged"
+}
+
+// Mark a property as being for internal use only (no shown to users)
+func (keep *UpcloudKeepProperty) Usage() api_usage.Usage {
+	return api_property.Usage_Optional()
+}
+
+// Copy the property
+func (keep *UpcloudKeepProperty) Copy() api_property.Property {
+	prop := &UpcloudKeepProperty{}
+	prop.Set(keep.Get())
+	return api_property.Property(prop)
+}
+
+// A boolean flag that tells an operation to only report what it would change
+type UpcloudDryRunProperty struct {
+	api_property.BooleanProperty
+}
+
+// ID returns string unique property Identifier
+func (dryRun *UpcloudDryRunProperty) Id() string {
+	return UPCLOUD_DRYRUN_PROPERTY
+}
+
+// Label returns a short user readable label for the property
+func (dryRun *UpcloudDryRunProperty) Label() string {
+	return "Dry run"
+}
+
+// Description provides a longer multi-line string description of what the property does
+func (dryRun *UpcloudDryRunProperty) Description() string {
+	return "Only report the UpCloud resources that would be changed, without changing them"
+}
+
+// Mark a property as being for internal use only (no shown to users)
+func (dryRun *UpcloudDryRunProperty) Usage() api_usage.Usage {
+	return api_property.Usage_Optional()
+}
+
+// Copy the property
+func (dryRun *UpcloudDryRunProperty) Copy() api_property.Property {
+	prop := &UpcloudDryRunProperty{}
+	prop.Set(dryRun.Get())
+	return api_property.Property(prop)
+}
+
+// A duration string (like "5m") that overrides the timeouts used when waiting for UpCloud
+type UpcloudTimeoutProperty struct {
+	api_property.StringProperty
+}
+
+// ID returns string unique property Identifier
+func (timeout *UpcloudTimeoutProperty) Id() string {
+	return UPCLOUD_TIMEOUT_PROPERTY
+}
+
+// Label returns a short user readable label for the property
+func (timeout *UpcloudTimeoutProperty) Label() string {
+	return "UpCloud timeout"
+}
+
+// Description provides a longer multi-line string description of what the property does
+func (timeout *UpcloudTimeoutProperty) Description() string {
+	return "How long to wait for UpCloud servers to change state, such as 90s or 5m"
+}
+
+// Mark a property as being for internal use only (no shown to users)
+func (timeout *UpcloudTimeoutProperty) Usage() api_usage.Usage {
+	return api_property.Usage_Optional()
+}
+
+// Copy the property
+func (timeout *UpcloudTimeoutProperty) Copy() api_property.Property {
+	prop := &UpcloudTimeoutProperty{}
+	prop.Set(timeout.Get())
+	return api_property.Property(prop)
+}
+
 // A string slice property to match to server UUID
 type UpcloudServerUUIDProperty struct {
 	api_property.StringProperty
@@ -191,6 +372,486 @@ func (uuids *UpcloudServerUUIDSProperty) Copy() api_property.Property {
 	return api_property.Property(prop)
 }
 
+// A string slice of server ids or UUIDs, confirming that protected servers may be deleted
+type UpcloudConfirmProperty struct {
+	api_property.StringSliceProperty
+}
+
+// ID returns string unique property Identifier
+func (confirm *UpcloudConfirmProperty) Id() string {
+	return UPCLOUD_CONFIRM_PROPERTY
+}
+
+// Label returns a short user readable label for the property
+func (confirm *UpcloudConfirmProperty) Label() string {
+	return "Confirm servers"
+}
+
+// Description provides a longer multi-line string description of what the property does
+func (confirm *UpcloudConfirmProperty) Description() string {
+	return "List of server ids or UUIDs confirmed for a change that is otherwise refused, such as deleting a protected server"
+}
+
+// Mark a property as being for internal use only (no shown to users)
+func (confirm *UpcloudConfirmProperty) Usage() api_usage.Usage {
+	return api_property.Usage_Optional()
+}
+
+// Copy the property
+func (confirm *UpcloudConfirmProperty) Copy() api_property.Property {
+	prop := &UpcloudConfirmProperty{}
+	prop.Set(confirm.Get())
+	return api_property.Property(prop)
+}
+
+// A replica group id, which is the id of a server definition with a Count
+type UpcloudGroupProperty struct {
+	api_property.StringProperty
+}
+
+// ID returns string unique property Identifier
+func (group *UpcloudGroupProperty) Id() string {
+	return UPCLOUD_GROUP_PROPERTY
+}
+
+// Label returns a short user readable label for the property
+func (group *UpcloudGroupProperty) Label() string {
+	return "Replica group"
+}
+
+// Description provides a longer multi-line string description of what the property does
+func (group *UpcloudGroupProperty) Description() string {
+	return "Id of a server replica group from the project configuration"
+}
+
+// Mark a property as being for internal use only (no shown to users)
+func (group *UpcloudGroupProperty) Usage() api_usage.Usage {
+	return api_property.Usage_Optional()
+}
+
+// Copy the property
+func (group *UpcloudGroupProperty) Copy() api_property.Property {
+	prop := &UpcloudGroupProperty{}
+	prop.Set(group.Get())
+	return api_property.Property(prop)
+}
+
+// A number of replicas, as a string
+type UpcloudCountProperty struct {
+	api_property.StringProperty
+}
+
+// ID returns string unique property Identifier
+func (count *UpcloudCountProperty) Id() string {
+	return UPCLOUD_COUNT_PROPERTY
+}
+
+// Label returns a short user readable label for the property
+func (count *UpcloudCountProperty) Label() string {
+	return "Replica count"
+}
+
+// Description provides a longer multi-line string description of what the property does
+func (count *UpcloudCountProperty) Description() string {
+	return "Number of servers in a replica group"
+}
+
+// Mark a property as being for internal use only (no shown to users)
+func (count *UpcloudCountProperty) Usage() api_usage.Usage {
+	return api_property.Usage_Optional()
+}
+
+// Copy the property
+func (count *UpcloudCountProperty) Copy() api_property.Property {
+	prop := &UpcloudCountProperty{}
+	prop.Set(count.Get())
+	return api_property.Property(prop)
+}
+
+// A shell command to run on servers
+type UpcloudCommandProperty struct {
+	api_property.StringProperty
+}
+
+// ID returns string unique property Identifier
+func (command *UpcloudCommandProperty) Id() string {
+	return UPCLOUD_COMMAND_PROPERTY
+}
+
+// Label returns a short user readable label for the property
+func (command *UpcloudCommandProperty) Label() string {
+	return "Command"
+}
+
+// Description provides a longer multi-line string description of what the property does
+func (command *UpcloudCommandProperty) Description() string {
+	return "Shell command to run on the UpCloud servers"
+}
+
+// Mark a property as being for internal use only (no shown to users)
+func (command *UpcloudCommandProperty) Usage() api_usage.Usage {
+	return api_property.Usage_Optional()
+}
+
+// Copy the property
+func (command *UpcloudCommandProperty) Copy() api_property.Property {
+	prop := &UpcloudCommandProperty{}
+	prop.Set(command.Get())
+	return api_property.Property(prop)
+}
+
+// A string slice of server ids from the project configuration
+type UpcloudServerIdsProperty struct {
+	api_property.StringSliceProperty
+}
+
+// ID returns string unique property Identifier
+func (ids *UpcloudServerIdsProperty) Id() string {
+	return UPCLOUD_SERVER_IDS_PROPERTY
+}
+
+// Label returns a short user readable label for the property
+func (ids *UpcloudServerIdsProperty) Label() string {
+	return "Server ids"
+}
+
+// Description provides a longer multi-line string description of what the property does
+func (ids *UpcloudServerIdsProperty) Description() string {
+	return "List of server ids from the project configuration"
+}
+
+// Mark a property as being for internal use only (no shown to users)
+func (ids *UpcloudServerIdsProperty) Usage() api_usage.Usage {
+	return api_property.Usage_Optional()
+}
+
+// Copy the property
+func (ids *UpcloudServerIdsProperty) Copy() api_property.Property {
+	prop := &UpcloudServerIdsProperty{}
+	prop.Set(ids.Get())
+	return api_property.Property(prop)
+}
+
+// A server tag to filter servers by
+type UpcloudTagProperty struct {
+	api_property.StringProperty
+}
+
+// ID returns string unique property Identifier
+func (tag *UpcloudTagProperty) Id() string {
+	return UPCLOUD_TAG_PROPERTY
+}
+
+// Label returns a short user readable label for the property
+func (tag *UpcloudTagProperty) Label() string {
+	return "UpCloud tag"
+}
+
+// Description provides a longer multi-line string description of what the property does
+func (tag *UpcloudTagProperty) Description() string {
+	return "Only use UpCloud servers with this tag"
+}
+
+// Mark a property as being for internal use only (no shown to users)
+func (tag *UpcloudTagProperty) Usage() api_usage.Usage {
+	return api_property.Usage_Optional()
+}
+
+// Copy the property
+func (tag *UpcloudTagProperty) Copy() api_property.Property {
+	prop := &UpcloudTagProperty{}
+	prop.Set(tag.Get())
+	return api_property.Property(prop)
+}
+
+// A server role to filter servers by
+type UpcloudRoleProperty struct {
+	api_property.StringProperty
+}
+
+// ID returns string unique property Identifier
+func (role *UpcloudRoleProperty) Id() string {
+	return UPCLOUD_ROLE_PROPERTY
+}
+
+// Label returns a short user readable label for the property
+func (role *UpcloudRoleProperty) Label() string {
+	return "Server role"
+}
+
+// Description provides a longer multi-line string description of what the property does
+func (role *UpcloudRoleProperty) Description() string {
+	return "Only use servers with this role in the project configuration"
+}
+
+// Mark a property as being for internal use only (no shown to users)
+func (role *UpcloudRoleProperty) Usage() api_usage.Usage {
+	return api_property.Usage_Optional()
+}
+
+// Copy the property
+func (role *UpcloudRoleProperty) Copy() api_property.Property {
+	prop := &UpcloudRoleProperty{}
+	prop.Set(role.Get())
+	return api_property.Property(prop)
+}
+
+// A boolean flag that stops an operation at the first failure
+type UpcloudFailFastProperty struct {
+	api_property.BooleanProperty
+}
+
+// ID returns string unique property Identifier
+func (failFast *UpcloudFailFastProperty) Id() string {
+	return UPCLOUD_FAILFAST_PROPERTY
+}
+
+// Label returns a short user readable label for the property
+func (failFast *UpcloudFailFastProperty) Label() string {
+	return "Fail fast"
+}
+
+// Description provides a longer multi-line string description of what the property does
+func (failFast *UpcloudFailFastProperty) Description() string {
+	return "Stop starting work on more servers after the first failure"
+}
+
+// Mark a property as being for internal use only (no shown to users)
+func (failFast *UpcloudFailFastProperty) Usage() api_usage.Usage {
+	return api_property.Usage_Optional()
+}
+
+// Copy the property
+func (failFast *UpcloudFailFastProperty) Copy() api_property.Property {
+	prop := &UpcloudFailFastProperty{}
+	prop.Set(failFast.Get())
+	return api_property.Property(prop)
+}
+
+// An output format
+type UpcloudFormatProperty struct {
+	api_property.StringProperty
+}
+
+// ID returns string unique property Identifier
+func (format *UpcloudFormatProperty) Id() string {
+	return UPCLOUD_FORMAT_PROPERTY
+}
+
+// Label returns a short user readable label for the property
+func (format *UpcloudFormatProperty) Label() string {
+	return "Output format"
+}
+
+// Description provides a longer multi-line string description of what the property does
+func (format *UpcloudFormatProperty) Description() string {
+	return "Format of the generated output"
+}
+
+// Mark a property as being for internal use only (no shown to users)
+func (format *UpcloudFormatProperty) Usage() api_usage.Usage {
+	return api_property.Usage_Optional()
+}
+
+// Copy the property
+func (format *UpcloudFormatProperty) Copy() api_property.Property {
+	prop := &UpcloudFormatProperty{}
+	prop.Set(format.Get())
+	return api_property.Property(prop)
+}
+
+// A file path to write generated output to
+type UpcloudOutputProperty struct {
+	api_property.StringProperty
+}
+
+// ID returns string unique property Identifier
+func (output *UpcloudOutputProperty) Id() string {
+	return UPCLOUD_OUTPUT_PROPERTY
+}
+
+// Label returns a short user readable label for the property
+func (output *UpcloudOutputProperty) Label() string {
+	return "Output file"
+}
+
+// Description provides a longer multi-line string description of what the property does
+func (output *UpcloudOutputProperty) Description() string {
+	return "Path of a file to write the output to, or empty to write to stdout"
+}
+
+// Mark a property as being for internal use only (no shown to users)
+func (output *UpcloudOutputProperty) Usage() api_usage.Usage {
+	return api_property.Usage_Optional()
+}
+
+// Copy the property
+func (output *UpcloudOutputProperty) Copy() api_property.Property {
+	prop := &UpcloudOutputProperty{}
+	prop.Set(output.Get())
+	return api_property.Property(prop)
+}
+
+// A single host name
+type UpcloudHostProperty struct {
+	api_property.StringProperty
+}
+
+// ID returns string unique property Identifier
+func (host *UpcloudHostProperty) Id() string {
+	return UPCLOUD_HOST_PROPERTY
+}
+
+// Label returns a short user readable label for the property
+func (host *UpcloudHostProperty) Label() string {
+	return "Host"
+}
+
+// Description provides a longer multi-line string description of what the property does
+func (host *UpcloudHostProperty) Description() string {
+	return "A single host to report on"
+}
+
+// Mark a property as being for internal use only (no shown to users)
+func (host *UpcloudHostProperty) Usage() api_usage.Usage {
+	return api_property.Usage_Optional()
+}
+
+// Copy the property
+func (host *UpcloudHostProperty) Copy() api_property.Property {
+	prop := &UpcloudHostProperty{}
+	prop.Set(host.Get())
+	return api_property.Property(prop)
+}
+
+// A dot separated key into a value tree
+type UpcloudKeyProperty struct {
+	api_property.StringProperty
+}
+
+// ID returns string unique property Identifier
+func (key *UpcloudKeyProperty) Id() string {
+	return UPCLOUD_KEY_PROPERTY
+}
+
+// Label returns a short user readable label for the property
+func (key *UpcloudKeyProperty) Label() string {
+	return "Key"
+}
+
+// Description provides a longer multi-line string description of what the property does
+func (key *UpcloudKeyProperty) Description() string {
+	return "A dot separated key, such as servers.web.ipv4"
+}
+
+// Mark a property as being for internal use only (no shown to users)
+func (key *UpcloudKeyProperty) Usage() api_usage.Usage {
+	return api_property.Usage_Optional()
+}
+
+// Copy the property
+func (key *UpcloudKeyProperty) Copy() api_property.Property {
+	prop := &UpcloudKeyProperty{}
+	prop.Set(key.Get())
+	return api_property.Property(prop)
+}
+
+// A value returned by an operation
+type UpcloudValueProperty struct {
+	api_property.StringProperty
+}
+
+// ID returns string unique property Identifier
+func (value *UpcloudValueProperty) Id() string {
+	return UPCLOUD_VALUE_PROPERTY
+}
+
+// Label returns a short user readable label for the property
+func (value *UpcloudValueProperty) Label() string {
+	return "Value"
+}
+
+// Description provides a longer multi-line string description of what the property does
+func (value *UpcloudValueProperty) Description() string {
+	return "The value found for the key"
+}
+
+// Mark a property as being for internal use only (no shown to users)
+func (value *UpcloudValueProperty) Usage() api_usage.Usage {
+	return api_property.Usage_Optional()
+}
+
+// Copy the property
+func (value *UpcloudValueProperty) Copy() api_property.Property {
+	prop := &UpcloudValueProperty{}
+	prop.Set(value.Get())
+	return api_property.Property(prop)
+}
+
+// A polling interval, as a duration string such as 10s
+type UpcloudIntervalProperty struct {
+	api_property.StringProperty
+}
+
+// ID returns string unique property Identifier
+func (interval *UpcloudIntervalProperty) Id() string {
+	return UPCLOUD_INTERVAL_PROPERTY
+}
+
+// Label returns a short user readable label for the property
+func (interval *UpcloudIntervalProperty) Label() string {
+	return "Interval"
+}
+
+// Description provides a longer multi-line string description of what the property does
+func (interval *UpcloudIntervalProperty) Description() string {
+	return "How often to poll UpCloud, as a duration such as 10s"
+}
+
+// Mark a property as being for internal use only (no shown to users)
+func (interval *UpcloudIntervalProperty) Usage() api_usage.Usage {
+	return api_property.Usage_Optional()
+}
+
+// Copy the property
+func (interval *UpcloudIntervalProperty) Copy() api_property.Property {
+	prop := &UpcloudIntervalProperty{}
+	prop.Set(interval.Get())
+	return api_property.Property(prop)
+}
+
+// A local address to listen on, such as 127.0.0.1:9732
+type UpcloudListenProperty struct {
+	api_property.StringProperty
+}
+
+// ID returns string unique property Identifier
+func (listen *UpcloudListenProperty) Id() string {
+	return UPCLOUD_LISTEN_PROPERTY
+}
+
+// Label returns a short user readable label for the property
+func (listen *UpcloudListenProperty) Label() string {
+	return "Listen address"
+}
+
+// Description provides a longer multi-line string description of what the property does
+func (listen *UpcloudListenProperty) Description() string {
+	return "Local host and port to listen on, such as 127.0.0.1:9732"
+}
+
+// Mark a property as being for internal use only (no shown to users)
+func (listen *UpcloudListenProperty) Usage() api_usage.Usage {
+	return api_property.Usage_Optional()
+}
+
+// Copy the property
+func (listen *UpcloudListenProperty) Copy() api_property.Property {
+	prop := &UpcloudListenProperty{}
+	prop.Set(listen.Get())
+	return api_property.Property(prop)
+}
+
 // A string slice property to match to storage UUID
 type UpcloudStorageUUIDProperty struct {
 	api_property.StringProperty
@@ -436,3 +1097,53 @@ func (firewallRules *UpcloudFirewallRulesProperty) Copy() api_property.Property
 	prop.Set(firewallRules.Get())
 	return api_property.Property(prop)
 }
+
+// A property for the Account, so that account information can be used by other tools
+type UpcloudAccountProperty struct {
+	value upcloud.Account
+}
+
+// ID returns string unique property Identifier
+func (account *UpcloudAccountProperty) Id() string {
+	return UPCLOUD_ACCOUNT_PROPERTY
+}
+
+// Label returns a short user readable label for the property
+func (account *UpcloudAccountProperty) Label() string {
+	return "UpCloud account"
+}
+
+// Description provides a longer multi-line string description of what the property does
+func (account *UpcloudAccountProperty) Description() string {
+	return "UpCloud account object"
+}
+
+// Mark a property as being for internal use only (no shown to users)
+func (account *UpcloudAccountProperty) Usage() api_usage.Usage {
+	return api_property.Usage_Optional()
+}
+
+// Give an idea of what type of value the property consumes
+func (account *UpcloudAccountProperty) Type() string {
+	return "github.com/Jalle19/upcloud-go-sdk/upcloud/Account"
+}
+
+func (account *UpcloudAccountProperty) Get() interface{} {
+	return interface{}(account.value)
+}
+func (account *UpcloudAccountProperty) Set(value interface{}) bool {
+	if converted, ok := value.(upcloud.Account); ok {
+		account.value = converted
+		return true
+	} else {
+		log.WithFields(log.Fields{"value": value}).Error("Could not assign Property value, because the passed parameter was the wrong type. Expected UpCloud Account")
+		return false
+	}
+}
+
+// Copy the property
+func (account *UpcloudAccountProperty) Copy() api_property.Property {
+	prop := &UpcloudAccountProperty{}
+	prop.Set(account.Get())
+	return api_property.Property(prop)
+}
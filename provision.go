@@ -2,7 +2,6 @@ package upcloud
 
 import (
 	"errors"
-	"time"
 
 	log "github.com/Sirupsen/logrus"
 
@@ -35,9 +34,13 @@ func (provision *UpcloudProvisionHandler) Operations() api_operation.Operations
 
 	ops := api_operation.New_SimpleOperations()
 
+	ops.Add(api_operation.Operation(&UpcloudProvisionPreflightOperation{BaseUpcloudServiceOperation: *baseOperation}))
 	ops.Add(api_operation.Operation(&UpcloudProvisionUpOperation{BaseUpcloudServiceOperation: *baseOperation}))
 	ops.Add(api_operation.Operation(&UpcloudProvisionStopOperation{BaseUpcloudServiceOperation: *baseOperation}))
 	ops.Add(api_operation.Operation(&UpcloudProvisionDownOperation{BaseUpcloudServiceOperation: *baseOperation}))
+	ops.Add(api_operation.Operation(&UpcloudProvisionRefreshOperation{BaseUpcloudServiceOperation: *baseOperation}))
+	ops.Add(api_operation.Operation(&UpcloudProvisionScaleOperation{BaseUpcloudServiceOperation: *baseOperation}))
+	ops.Add(api_operation.Operation(&UpcloudProvisionCleanupOperation{BaseUpcloudServiceOperation: *baseOperation}))
 
 	return ops.Operations()
 }
@@ -55,6 +58,9 @@ func (provision *UpcloudProvisionHandler) Id() string {
 type UpcloudProvisionUpOperation struct {
 	BaseUpcloudServiceOperation
 	api_provision.BaseProvisionUpOperation
+
+	// provision only these servers, instead of all project servers
+	serverDefinitions *ServerDefinitions
 }
 
 // Return the string machinename/id of the Operation
@@ -79,36 +85,205 @@ func (up *UpcloudProvisionUpOperation) Validate() api_result.Result {
 
 // What settings/values does the Operation provide to an implemenentor
 func (up *UpcloudProvisionUpOperation) Properties() api_property.Properties {
-	return api_property.New_SimplePropertiesEmpty().Properties()
+	props := api_property.New_SimplePropertiesEmpty()
+
+	props.Add(api_property.Property(&UpcloudPreflightProperty{}))
+	props.Add(api_property.Property(&UpcloudRollbackProperty{}))
+	props.Add(api_property.Property(&UpcloudKeepProperty{}))
+	props.Add(api_property.Property(&UpcloudTimeoutProperty{}))
+	props.Add(api_property.Property(&UpcloudDryRunProperty{}))
+
+	return props.Properties()
 }
 
 /**
  * Execute the Operation
  *
+ * If the preflight property is set, then the preflight checks are
+ * run first, and nothing is created if they fail.  If the project
+ * has a budget, then nothing is created if the estimated cost of
+ * the servers exceeds it.
+ *
  * The following steps are followed for each server:
  *   1. create the server - then wait for it to be considered running
  *   2. create the firewall rules
  *   3. tag the server
+ *   4. run the readiness probes, until they pass or run out of retries
+ *   5. run the post create hooks over ssh, stopping at the first failure
+ *
+ * Servers are created in dependency order, and before a server with
+ * dependencies is created, each dependency is waited for until it
+ * has started.  A server whose dependencies failed is not created.
+ * As each server only waits on its own dependencies, this also
+ * holds if servers are ever provisioned in parallel.
+ *
+ * In rollback mode (the rollback property or the Rollback builder
+ * setting) provisioning stops at the first failure, and all servers
+ * created in this run are stopped and deleted, in reverse order.  The
+ * keep property disables this, leaving the servers for debugging.
+ * The rolled back servers and storages are returned in the failed
+ * result.
+ *
+ * Created servers are recorded in the local state, which is saved
+ * once the run is finished.
+ *
+ * Server UserData is rendered just before each server is created, so
+ * that it can use the IPs of the servers it depends on.  In a dry run
+ * the create requests and rendered UserData are only logged.
  *
  * @TODO build properties properly from the child operations
  * @TODO This operation should operate in parrallel
  */
 func (up *UpcloudProvisionUpOperation) Exec(props api_property.Properties) api_result.Result {
-	res := api_result.New_StandardResult()
+	res := New_UpcloudCommandResult()
+
+	service := up.ServiceWrapper()
+	settings := up.BuilderSettings()
+	projectDefinitions := up.ServerDefinitions()
+	serverDefinitions := up.serverDefinitions
+	if serverDefinitions == nil {
+		serverDefinitions = projectDefinitions
+	}
+	state := up.State()
+
+	preflight := false
+	if preflightProp, found := props.Get(UPCLOUD_PREFLIGHT_PROPERTY); found {
+		preflight = preflightProp.Get().(bool)
+		log.WithFields(log.Fields{"key": UPCLOUD_PREFLIGHT_PROPERTY, "prop": preflightProp, "value": preflight}).Debug("UP: Run preflight checks")
+	}
+	rollback := settings.Rollback
+	if rollbackProp, found := props.Get(UPCLOUD_ROLLBACK_PROPERTY); found && rollbackProp.Get().(bool) {
+		rollback = true
+		log.WithFields(log.Fields{"key": UPCLOUD_ROLLBACK_PROPERTY, "prop": rollbackProp, "value": rollback}).Debug("UP: Rollback on failure")
+	}
+	dryRun := false
+	if dryRunProp, found := props.Get(UPCLOUD_DRYRUN_PROPERTY); found {
+		dryRun = dryRunProp.Get().(bool)
+		log.WithFields(log.Fields{"key": UPCLOUD_DRYRUN_PROPERTY, "prop": dryRunProp, "value": dryRun}).Debug("UP: Dry run")
+	}
+	if keepProp, found := props.Get(UPCLOUD_KEEP_PROPERTY); found && keepProp.Get().(bool) {
+		rollback = false
+		log.WithFields(log.Fields{"key": UPCLOUD_KEEP_PROPERTY, "prop": keepProp, "value": true}).Debug("UP: Keep servers on failure")
+	}
+
+	if preflight {
+		preflightOp := UpcloudProvisionPreflightOperation{BaseUpcloudServiceOperation: up.BaseUpcloudServiceOperation}
+
+		preflightResult := preflightOp.Exec(preflightOp.Properties())
+		<-preflightResult.Finished()
+
+		if !preflightResult.Success() {
+			res.AddErrors(preflightResult.Errors())
+			res.AddError(errors.New("Preflight checks failed, so no servers were provisioned."))
+			res.MarkFailed()
+			res.MarkFinished()
+			return res.Result()
+		}
+	}
+
+	if !settings.Budget.Empty() {
+		estimate, err := estimateProjectCost(service, serverDefinitions)
+		if err == nil {
+			err = settings.Budget.Check(estimate)
+		}
+		if err != nil {
+			res.AddError(err)
+			res.AddError(errors.New("Project budget check failed, so no servers were provisioned."))
+			res.MarkFailed()
+			res.MarkFinished()
+			return res.Result()
+		}
+		log.WithFields(log.Fields{"hourly": formatCredits(estimate.Hourly()), "monthly": formatCredits(estimate.Monthly())}).Info("UP: Project cost estimate is within the budget")
+	}
 
 	createOp := UpcloudServerCreateOperation{BaseUpcloudServiceOperation: up.BaseUpcloudServiceOperation}
 	createProperties := createOp.Properties()
 
-	service := up.ServiceWrapper()
-	// settings := up.BuilderSettings()
-	serverDefinitions := up.ServerDefinitions()
+	// dependencies are resolved across the whole project
+	order, err := projectDefinitions.DependencyOrder()
+	if err != nil {
+		res.AddError(err)
+		res.AddError(errors.New("Server dependencies are invalid, so no servers were provisioned."))
+		res.MarkFailed()
+		res.MarkFinished()
+		return res.Result()
+	}
+
+	if dryRun {
+		for _, id := range order {
+			serverDefinition, found := serverDefinitions.Get(id)
+			if !found {
+				continue
+			}
+			if errs := serverDefinition.Validate(); len(errs) > 0 {
+				res.AddErrors(errs)
+				res.AddError(errors.New("UpCloud server definition is invalid : " + id))
+				res.MarkFailed()
+			}
+			createRequest := serverDefinition.CreateServerRequest()
+			userData, err := serverDefinition.DryRunUserData()
+			if err != nil {
+				res.AddError(err)
+				res.AddError(errors.New("Could not render UserData for server : " + id))
+				res.MarkFailed()
+			}
+			log.WithFields(log.Fields{"id": id, "title": createRequest.Title, "hostname": createRequest.Hostname, "zone": createRequest.Zone, "plan": createRequest.Plan, "dependencies": projectDefinitions.Dependencies(id)}).Info("UP: Dry run, server would be created")
+			if userData != "" {
+				log.WithFields(log.Fields{"id": id}).Info("UP: Dry run, rendered UserData:\n" + userData)
+			}
+		}
+		res.MarkFinished()
+		return res.Result()
+	}
 
 	// track which servers we actually create here
-	createdServers := map[string]processedServer{}
+	createdServers := []processedServer{}
+	createdUUIDs := map[string]string{}
+	started := map[string]bool{}
+	transaction := provisionTransaction{}
+	failed := false
+
+	for _, id := range order {
+		serverDefinition, found := serverDefinitions.Get(id)
+		if !found {
+			continue
+		}
+
+		if err := up.waitForDependencies(service, projectDefinitions, id, createdUUIDs, started, props); err != nil {
+			res.AddError(err)
+			res.AddError(errors.New("Dependencies of UpCloud server did not start, so it was not provisioned: " + id))
+			res.MarkFailed()
+			failed = true
+			if rollback {
+				break
+			}
+			continue
+		}
+
+		if errs := serverDefinition.Validate(); len(errs) > 0 {
+			res.AddErrors(errs)
+			res.AddError(errors.New("UpCloud server definition is invalid, so it was not provisioned: " + id))
+			res.MarkFailed()
+			failed = true
+			if rollback {
+				break
+			}
+			continue
+		}
 
-	for _, id := range serverDefinitions.Order() {
-		serverDefinition, _ := serverDefinitions.Get(id)
 		createRequest := serverDefinition.CreateServerRequest()
+		if userData, err := serverDefinition.UserData(); err != nil {
+			res.AddError(err)
+			res.AddError(errors.New("Could not render UserData, so UpCloud server was not provisioned: " + id))
+			res.MarkFailed()
+			failed = true
+			if rollback {
+				break
+			}
+			continue
+		} else {
+			createRequest.UserData = userData
+		}
 
 		if requestProp, found := createProperties.Get(UPCLOUD_SERVER_CREATEREQUEST_PROPERTY); found {
 			requestProp.Set(createRequest)
@@ -123,6 +298,10 @@ func (up *UpcloudProvisionUpOperation) Exec(props api_property.Properties) api_r
 			res.AddErrors(createResult.Errors())
 			res.AddError(errors.New("Could not provision new UpCloud server: " + id))
 			res.MarkFailed()
+			failed = true
+			if rollback {
+				break
+			}
 			continue
 		} else {
 
@@ -133,11 +312,15 @@ func (up *UpcloudProvisionUpOperation) Exec(props api_property.Properties) api_r
 
 			uuid := createDetails.UUID
 
-			createdServers[id] = processedServer{
+			createdServers = append(createdServers, processedServer{
 				uuid:       uuid,
 				definition: serverDefinition,
 				details:    createDetails,
-			}
+			})
+			storages := createdStorages(serverDefinition.CreateServerRequest(), createDetails)
+			transaction.AddServer(id, createDetails, storages)
+			createdUUIDs[id] = uuid
+			state.Set(id, New_UpcloudStateServer(createDetails, storages, serverDefinition.GetFirewallRules()))
 
 			log.WithFields(log.Fields{"id": serverDefinition.Id(), "UUID": uuid, "state": createDetails.State}).Info("Created new server")
 		}
@@ -148,18 +331,23 @@ func (up *UpcloudProvisionUpOperation) Exec(props api_property.Properties) api_r
 
 	// process tags and firewall rules
 	for _, createdServer := range createdServers {
+		if failed && rollback {
+			break
+		}
+
 		uuid := createdServer.uuid
 		serverDefinition := createdServer.definition
 
 		// Before running anything, give the server a chance to get into the proper state
 		log.WithFields(log.Fields{"id": serverDefinition.Id(), "UUID": uuid}).Info("Waiting for new server to start")
-		if serverDetails, err := service.WaitForServerState(&upcloud_request.WaitForServerStateRequest{UUID: uuid, UndesiredState: "maintenance", Timeout: time.Minute * 2}); err != nil {
+		if serverDetails, err := service.WaitForServerState(&upcloud_request.WaitForServerStateRequest{UUID: uuid, UndesiredState: "maintenance", Timeout: up.Timeout(UPCLOUD_TIMEOUT_CREATE, props)}); err != nil {
 			if serverDetails != nil {
 				uuid = serverDetails.UUID
 			}
 			res.AddError(err)
 			res.AddError(errors.New("Server failed to start properly : " + uuid))
 			res.MarkFailed()
+			failed = true
 		} else {
 			log.WithFields(log.Fields{"state": serverDetails.State, "UUID": serverDetails.UUID}).Info("Server successfully created, now finalizing provisioning")
 
@@ -178,9 +366,63 @@ func (up *UpcloudProvisionUpOperation) Exec(props api_property.Properties) api_r
 
 			if !firewallResult.Success() {
 				res.Merge(firewallResult)
+				res.AddError(errors.New("Could not apply firewall rules to server : " + uuid))
+				res.MarkFailed()
+				failed = true
 				continue
 			}
 
+			if serverDefinition.IsProtected() {
+				if _, err := service.TagServer(&upcloud_request.TagServerRequest{UUID: uuid, Tags: []string{UPCLOUD_PROTECTED_TAG}}); err != nil {
+					res.AddError(err)
+					res.AddError(errors.New("Could not tag server as protected : " + uuid))
+					res.MarkFailed()
+					failed = true
+					continue
+				}
+				log.WithFields(log.Fields{"id": serverDefinition.Id(), "UUID": uuid}).Info("Server tagged as protected")
+			}
+
+			if probes := serverDefinition.ReadinessProbes(); len(probes) > 0 {
+				log.WithFields(log.Fields{"id": serverDefinition.Id(), "UUID": uuid, "probes": len(probes)}).Info("Waiting for server to be ready")
+				if errs := runReadinessProbes(serverDefinition.Id(), serverDetails, probes); len(errs) > 0 {
+					res.AddErrors(errs)
+					res.AddError(errors.New("Server did not become ready : " + uuid))
+					res.MarkFailed()
+					failed = true
+					continue
+				}
+			}
+
+			if hooks := serverDefinition.PostCreateHooks(); len(hooks) > 0 {
+				user := ""
+				if request := serverDefinition.CreateServerRequest(); request.LoginUser != nil {
+					user = request.LoginUser.Username
+				}
+				target, err := newServerSSHTarget(settings.SSH, serverDetails, user)
+				if err == nil {
+					if forgetErr := target.ForgetHostKey(); forgetErr != nil {
+						log.WithError(forgetErr).Warn("Could not forget an old host key for a new server")
+					}
+					log.WithFields(log.Fields{"id": serverDefinition.Id(), "UUID": uuid, "hooks": len(hooks)}).Info("Running post create hooks")
+					outputs, errs := runPostCreateHooks(serverDefinition.Id(), target, hooks)
+					for index := range outputs {
+						outputs[index].UUID = uuid
+					}
+					res.AddOutputs(outputs)
+					if len(errs) > 0 {
+						err = errors.New("Post create hooks failed for server : " + uuid)
+						res.AddErrors(errs)
+					}
+				}
+				if err != nil {
+					res.AddError(err)
+					res.MarkFailed()
+					failed = true
+					continue
+				}
+			}
+
 			// var serverDetails upcloud.ServerDetails
 			// if detailsProp, found := createProperties.Get(UPCLOUD_SERVER_DETAILS_PROPERTY); found {
 			// 	serverDetails = detailsProp.Get().(upcloud.ServerDetails)
@@ -188,11 +430,68 @@ func (up *UpcloudProvisionUpOperation) Exec(props api_property.Properties) api_r
 		}
 	}
 
+	if failed && !transaction.Empty() {
+		if rollback {
+			log.Warn("UP: Provisioning failed, rolling back servers created in this run")
+			rolledBack, errs := transaction.Rollback(service, up.Timeout(UPCLOUD_TIMEOUT_STOP, props))
+			log.WithFields(log.Fields{"resources": rolledBack, "failures": len(errs)}).Info("UP: Rolled back servers created in this run")
+			res.AddRolledBack(rolledBack)
+			res.AddErrors(errs)
+
+			ids := []string{}
+			for _, resource := range transaction.resources {
+				ids = append(ids, resource.id)
+			}
+			if servers, err := service.GetServers(); err == nil {
+				state.Prune(ids, servers)
+			} else {
+				res.AddError(err)
+			}
+		} else {
+			for _, resource := range transaction.resources {
+				log.WithFields(log.Fields{"id": resource.id, "UUID": resource.uuid, "storages": resource.storages}).Warn("UP: Provisioning failed, keeping server created in this run")
+			}
+		}
+	}
+
+	if !transaction.Empty() {
+		if err := state.Save(); err != nil {
+			res.AddError(err)
+			res.AddError(errors.New("Could not save the UpCloud state file : " + state.Path()))
+		}
+	}
+
 	res.MarkFinished()
 
 	return res.Result()
 }
 
+// Wait for the dependencies of a server to start, using the servers created in this run, or already existing
+func (up *UpcloudProvisionUpOperation) waitForDependencies(service *UpcloudServiceWrapper, projectDefinitions *ServerDefinitions, id string, createdUUIDs map[string]string, started map[string]bool, props api_property.Properties) error {
+	for _, dependency := range projectDefinitions.Dependencies(id) {
+		if started[dependency] {
+			continue
+		}
+
+		uuid, created := createdUUIDs[dependency]
+		if !created {
+			dependencyDefinition, _ := projectDefinitions.Get(dependency)
+			existing, err := dependencyDefinition.UUID()
+			if err != nil {
+				return errors.New("Server " + id + " depends on a server that has not been provisioned : " + dependency)
+			}
+			uuid = existing
+		}
+
+		log.WithFields(log.Fields{"id": id, "dependency": dependency, "UUID": uuid}).Info("Waiting for dependency to start")
+		if _, err := service.WaitForServerState(&upcloud_request.WaitForServerStateRequest{UUID: uuid, DesiredState: upcloud.ServerStateStarted, Timeout: up.Timeout(UPCLOUD_TIMEOUT_START, props)}); err != nil {
+			return err
+		}
+		started[dependency] = true
+	}
+	return nil
+}
+
 // Provision up operation
 type UpcloudProvisionDownOperation struct {
 	BaseUpcloudServiceOperation
@@ -224,34 +523,64 @@ func (down *UpcloudProvisionDownOperation) Properties() api_property.Properties
 	props := api_property.New_SimplePropertiesEmpty()
 
 	props.Add(api_property.Property(&UpcloudForceProperty{}))
+	props.Add(api_property.Property(&UpcloudTimeoutProperty{}))
+	props.Add(api_property.Property(&UpcloudConfirmProperty{}))
 
 	return props.Properties()
 }
 
 // Execute the Operation
 //
+// Servers are removed in reverse dependency order.
+//
+// Protected servers, either marked Protected in the configuration or
+// tagged as protected, are skipped unless their id or UUID is passed
+// in the confirm property.
+//
 // @TODO Add a way to remove the storage
 // @TODO this operation could be optimized to work parrallel
 func (down *UpcloudProvisionDownOperation) Exec(props api_property.Properties) api_result.Result {
 	res := api_result.New_StandardResult()
 
-	downProperties := down.Properties()
 	deleteOp := UpcloudServerDeleteOperation{BaseUpcloudServiceOperation: down.BaseUpcloudServiceOperation}
 	deleteProperties := deleteOp.Properties()
 
-	// service := down.ServiceWrapper()
+	service := down.ServiceWrapper()
 	// settings := down.BuilderSettings()
 	serverDefinitions := down.ServerDefinitions()
+	state := down.State()
 
 	// collect UUIDs of project servers
 	uuids := []string{}
-	for _, id := range serverDefinitions.Order() {
+	ids := []string{}
+	confirmed := []string{}
+
+	// remove servers before the servers that they depend on
+	order, err := serverDefinitions.DependencyOrder()
+	if err != nil {
+		log.WithError(err).Warn("Down: Server dependencies are invalid, so servers are removed in reverse configuration order")
+		order = serverDefinitions.Order()
+	}
+	for index := len(order) - 1; index >= 0; index-- {
+		id := order[index]
 		serverDefinition, _ := serverDefinitions.Get(id)
 
 		if serverDefinition.IsCreated() {
 			uuid, _ := serverDefinition.UUID()
+
+			if protectionConfirmed(props, id, uuid) {
+				confirmed = append(confirmed, uuid)
+			} else if serverDefinition.IsProtected() {
+				log.WithFields(log.Fields{"id": id, "uuid": uuid}).Warn("Down: Server is protected, so it will be skipped")
+				res.AddError(errors.New("Skipped protected server, confirm it to delete it : " + id + " : " + uuid))
+				// the server remains, so the project is not down
+				res.MarkFailed()
+				continue
+			}
+
 			log.WithFields(log.Fields{"id": id, "uuid": uuid}).Debug("Down: Server added to list")
 			uuids = append(uuids, uuid)
+			ids = append(ids, id)
 		} else {
 			log.WithFields(log.Fields{"id": id}).Info("Down: Server has not been created, so it will be skipped")
 		}
@@ -263,7 +592,7 @@ func (down *UpcloudProvisionDownOperation) Exec(props api_property.Properties) a
 			log.WithFields(log.Fields{"uuids": uuids}).Info("DOWN: Using UUIDs")
 			uuidsProp.Set(uuids)
 		}
-		if downForceProp, found := downProperties.Get(UPCLOUD_FORCE_PROPERTY); found {
+		if downForceProp, found := props.Get(UPCLOUD_FORCE_PROPERTY); found {
 			if deleteForceProp, found := deleteProperties.Get(UPCLOUD_FORCE_PROPERTY); found {
 				if downForceProp.Get().(bool) {
 					log.Info("DOWN: Forcing operation")
@@ -271,13 +600,36 @@ func (down *UpcloudProvisionDownOperation) Exec(props api_property.Properties) a
 				}
 			}
 		}
+		if confirmProp, found := deleteProperties.Get(UPCLOUD_CONFIRM_PROPERTY); found {
+			confirmProp.Set(confirmed)
+		}
+		if downTimeoutProp, found := props.Get(UPCLOUD_TIMEOUT_PROPERTY); found {
+			if deleteTimeoutProp, found := deleteProperties.Get(UPCLOUD_TIMEOUT_PROPERTY); found {
+				deleteTimeoutProp.Set(downTimeoutProp.Get())
+			}
+		}
 
 		log.WithFields(log.Fields{"uuids": uuids}).Info("Downing project servers")
 
-		downResult := deleteOp.Exec(downProperties)
+		downResult := deleteOp.Exec(deleteProperties)
 		<-downResult.Finished()
 
 		res.Merge(downResult)
+		if !downResult.Success() {
+			res.MarkFailed()
+		}
+
+		// forget the servers which are now gone
+		if servers, err := service.GetServers(); err == nil {
+			pruned := state.Prune(ids, servers)
+			log.WithFields(log.Fields{"ids": pruned}).Debug("DOWN: Removed servers from the local state")
+			if err := state.Save(); err != nil {
+				res.AddError(err)
+				res.AddError(errors.New("Could not save the UpCloud state file : " + state.Path()))
+			}
+		} else {
+			res.AddError(err)
+		}
 
 	} else {
 		log.Info("No active servers found to take down.")
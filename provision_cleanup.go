@@ -0,0 +1,202 @@
+package upcloud
+
+import (
+	"errors"
+
+	log "github.com/Sirupsen/logrus"
+
+	upcloud_request "github.com/Jalle19/upcloud-go-sdk/upcloud/request"
+
+	api_operation "github.com/wunderkraut/radi-api/operation"
+	api_property "github.com/wunderkraut/radi-api/property"
+	api_result "github.com/wunderkraut/radi-api/result"
+	api_usage "github.com/wunderkraut/radi-api/usage"
+)
+
+/**
+ * Removal of orphaned UpCloud resources
+ */
+
+// Orphaned resource cleanup operation
+type UpcloudProvisionCleanupOperation struct {
+	BaseUpcloudServiceOperation
+}
+
+// Return the string machinename/id of the Operation
+func (cleanup *UpcloudProvisionCleanupOperation) Id() string {
+	return "upcloud.provision.cleanup"
+}
+
+// Return a user readable string label for the Operation
+func (cleanup *UpcloudProvisionCleanupOperation) Label() string {
+	return "Clean up UpCloud orphans"
+}
+
+// return a multiline string description for the Operation
+func (cleanup *UpcloudProvisionCleanupOperation) Description() string {
+	return "Remove UpCloud servers and storages left behind by the project."
+}
+
+// return a multiline string man page for the Operation
+func (cleanup *UpcloudProvisionCleanupOperation) Help() string {
+	return ""
+}
+
+// Is this operation meant to be used only inside the API
+func (cleanup *UpcloudProvisionCleanupOperation) Usage() api_usage.Usage {
+	return api_operation.Usage_External()
+}
+
+// Run a validation check on the Operation
+func (cleanup *UpcloudProvisionCleanupOperation) Validate() api_result.Result {
+	return api_result.MakeSuccessfulResult()
+}
+
+// What settings/values does the Operation provide to an implemenentor
+func (cleanup *UpcloudProvisionCleanupOperation) Properties() api_property.Properties {
+	props := api_property.New_SimplePropertiesEmpty()
+
+	// only report, unless a dry run is explicitly turned off
+	dryRun := &UpcloudDryRunProperty{}
+	dryRun.Set(true)
+	props.Add(api_property.Property(dryRun))
+	props.Add(api_property.Property(&UpcloudGlobalProperty{}))
+	props.Add(api_property.Property(&UpcloudTimeoutProperty{}))
+
+	return props.Properties()
+}
+
+/**
+ * Execute the Operation
+ *
+ * Orphaned servers are stopped and deleted with their storages,
+ * then orphaned storages are deleted.  Only resources recorded in
+ * the state, or with a project tag, are removed, and protected
+ * servers are skipped.  In a dry run, the orphans are only listed.
+ */
+func (cleanup *UpcloudProvisionCleanupOperation) Exec(props api_property.Properties) api_result.Result {
+	res := api_result.New_StandardResult()
+
+	service := cleanup.ServiceWrapper()
+	settings := cleanup.BuilderSettings()
+	serverDefinitions := cleanup.ServerDefinitions()
+	state := cleanup.State()
+
+	dryRun := true
+	if dryRunProp, found := props.Get(UPCLOUD_DRYRUN_PROPERTY); found {
+		dryRun = dryRunProp.Get().(bool)
+		log.WithFields(log.Fields{"key": UPCLOUD_DRYRUN_PROPERTY, "prop": dryRunProp, "value": dryRun}).Debug("CLEANUP: Dry run")
+	}
+	global := false
+	if globalProp, found := props.Get(UPCLOUD_GLOBAL_PROPERTY); found {
+		global = globalProp.Get().(bool)
+		log.WithFields(log.Fields{"key": UPCLOUD_GLOBAL_PROPERTY, "prop": globalProp, "value": global}).Debug("CLEANUP: Global")
+	}
+	service.SetGlobal(global)
+
+	report, err := findOrphans(service, serverDefinitions, state, settings)
+	if err != nil {
+		res.AddError(err)
+		res.AddError(errors.New("Could not retrieve UpCloud resources to look for orphans."))
+		res.MarkFailed()
+		res.MarkFinished()
+		return res.Result()
+	}
+	report.Log()
+
+	if dryRun {
+		if !report.Empty() {
+			log.Info("CLEANUP: Dry run, so nothing was removed")
+		}
+		res.MarkSuccess()
+		res.MarkFinished()
+		return res.Result()
+	}
+
+	failed := false
+
+	// orphaned servers are removed like a rolled back provisioning run
+	transaction := provisionTransaction{}
+	for _, server := range report.servers {
+		if hasProtectedTag(server.Tags) {
+			log.WithFields(log.Fields{"UUID": server.UUID, "title": server.Title}).Warn("CLEANUP: Orphaned server is protected, so it will be skipped")
+			res.AddError(errors.New("Skipped protected orphaned server : " + server.UUID))
+			failed = true
+			continue
+		}
+		if details, err := service.GetServerDetails(&upcloud_request.GetServerDetailsRequest{UUID: server.UUID}); err == nil {
+			// only storages recorded as created for the server are deleted with it
+			transaction.AddServer(server.Title, *details, state.ServerStorages(server.UUID))
+		} else {
+			res.AddError(err)
+			failed = true
+		}
+	}
+	removed, errs := transaction.Rollback(service, cleanup.Timeout(UPCLOUD_TIMEOUT_STOP, props))
+	if len(errs) > 0 {
+		res.AddErrors(errs)
+		failed = true
+	}
+	// forget the removed servers that were recorded in the state
+	if len(report.servers) > 0 {
+		if servers, err := service.GetServers(); err == nil {
+			orphaned := []string{}
+			storages := map[string][]string{}
+			for _, id := range state.Ids() {
+				recorded, _ := state.Get(id)
+				for _, server := range report.servers {
+					if server.UUID == recorded.UUID {
+						orphaned = append(orphaned, id)
+						storages[id] = recorded.Storages
+					}
+				}
+			}
+			// the storages were deleted with the servers, so they are not kept as released
+			for _, id := range state.Prune(orphaned, servers) {
+				log.WithFields(log.Fields{"id": id}).Debug("CLEANUP: Removed server forgotten from the state")
+				for _, storage := range storages[id] {
+					state.ForgetReleased(storage)
+				}
+			}
+		} else {
+			log.WithError(err).Warn("CLEANUP: Could not retrieve UpCloud servers, so removed servers are still in the state")
+		}
+	}
+
+	for _, storage := range report.storages {
+		if err := service.DeleteStorage(&upcloud_request.DeleteStorageRequest{UUID: storage.UUID}); err != nil {
+			res.AddError(err)
+			res.AddError(errors.New("Could not delete orphaned storage : " + storage.UUID))
+			failed = true
+			continue
+		}
+		state.ForgetReleased(storage.UUID)
+		removed = append(removed, "storage "+storage.Title+" : "+storage.UUID)
+	}
+	for _, uuid := range report.missing {
+		state.ForgetReleased(uuid)
+	}
+
+	// nothing ties these to the project, so they are only listed
+	if len(report.titled) > 0 || len(report.ips) > 0 {
+		log.WithFields(log.Fields{"servers": len(report.titled), "ips": len(report.ips)}).Info("CLEANUP: Resources which can't be proven to belong to the project were not removed")
+	}
+
+	for _, resource := range removed {
+		log.WithFields(log.Fields{"resource": resource}).Info("CLEANUP: Removed orphan")
+	}
+
+	if err := state.Save(); err != nil {
+		res.AddError(err)
+		res.AddError(errors.New("Could not save the UpCloud state file : " + state.Path()))
+	}
+
+	if failed {
+		res.MarkFailed()
+	} else {
+		res.MarkSuccess()
+	}
+	res.MarkFinished()
+
+	return res.Result()
+}
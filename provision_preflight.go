@@ -0,0 +1,242 @@
+package upcloud
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+
+	upcloud "github.com/Jalle19/upcloud-go-sdk/upcloud"
+	upcloud_request "github.com/Jalle19/upcloud-go-sdk/upcloud/request"
+
+	api_operation "github.com/wunderkraut/radi-api/operation"
+	api_property "github.com/wunderkraut/radi-api/property"
+	api_result "github.com/wunderkraut/radi-api/result"
+	api_usage "github.com/wunderkraut/radi-api/usage"
+)
+
+/**
+ * Preflight checks, which can be run before provisioning
+ * to find configuration and account problems before any
+ * servers are created.
+ */
+
+// Preflight check operation
+type UpcloudProvisionPreflightOperation struct {
+	BaseUpcloudServiceOperation
+}
+
+// Return the string machinename/id of the Operation
+func (preflight *UpcloudProvisionPreflightOperation) Id() string {
+	return "upcloud.provision.preflight"
+}
+
+// Return a user readable string label for the Operation
+func (preflight *UpcloudProvisionPreflightOperation) Label() string {
+	return "Check UpCloud provisioning"
+}
+
+// return a multiline string description for the Operation
+func (preflight *UpcloudProvisionPreflightOperation) Description() string {
+	return "Check the UpCloud account and server definitions before provisioning."
+}
+
+// return a multiline string man page for the Operation
+func (preflight *UpcloudProvisionPreflightOperation) Help() string {
+	return ""
+}
+
+// Is this operation meant to be used only inside the API
+func (preflight *UpcloudProvisionPreflightOperation) Usage() api_usage.Usage {
+	return api_operation.Usage_External()
+}
+
+// Run a validation check on the Operation
+func (preflight *UpcloudProvisionPreflightOperation) Validate() api_result.Result {
+	return api_result.MakeSuccessfulResult()
+}
+
+// What settings/values does the Operation provide to an implemenentor
+func (preflight *UpcloudProvisionPreflightOperation) Properties() api_property.Properties {
+	return api_property.New_SimplePropertiesEmpty().Properties()
+}
+
+/**
+ * Execute the Operation
+ *
+ * The following is checked:
+ *   1. the account can be retrieved and has enough credits
+ *   2. each server zone exists, and is allowed for the project
+ *   3. each server plan is offered, or custom cores/memory are set
+ *   4. each cloned storage refers to an existing template
+ *   5. each server definition is valid, including its SSH keys
+ *   6. server dependencies are known, and have no cycles
+ */
+func (preflight *UpcloudProvisionPreflightOperation) Exec(props api_property.Properties) api_result.Result {
+	res := api_result.New_StandardResult()
+
+	service := preflight.ServiceWrapper()
+	settings := preflight.BuilderSettings()
+	serverDefinitions := preflight.ServerDefinitions()
+
+	report := preflightReport{}
+
+	// account
+	if account, err := service.GetAccount(); err != nil {
+		report.Add("account", "credentials", err)
+	} else {
+		report.Add("account", account.UserName, settings.Credits.CheckMinimum(account.Credits, -1))
+	}
+
+	zones, zonesErr := service.GetZones()
+	if zonesErr != nil {
+		report.Add("zones", "list", zonesErr)
+	}
+	plans, plansErr := service.GetPlans()
+	if plansErr != nil {
+		report.Add("plans", "list", plansErr)
+	}
+	templates, templatesErr := service.GetStorages(&upcloud_request.GetStoragesRequest{Type: upcloud.StorageTypeTemplate})
+	if templatesErr != nil {
+		report.Add("templates", "list", templatesErr)
+	}
+
+	// dependencies
+	_, dependencyErr := serverDefinitions.DependencyOrder()
+	report.Add("dependencies", "order", dependencyErr)
+
+	for _, id := range serverDefinitions.Order() {
+		serverDefinition, _ := serverDefinitions.Get(id)
+		request := serverDefinition.CreateServerRequest()
+
+		// zone
+		if zonesErr == nil {
+			report.Add("zone", id, preflightCheckZone(request.Zone, zones, settings))
+		}
+
+		// plan
+		if plansErr == nil {
+			report.Add("plan", id, preflightCheckPlan(request, plans))
+		}
+
+		// template storages
+		if templatesErr == nil {
+			for _, device := range request.StorageDevices {
+				if !strings.EqualFold(device.Action, UPCLOUD_STORAGE_ACTION_CLONE) {
+					continue
+				}
+				report.Add("template", id+":"+device.Storage, preflightCheckTemplate(device.Storage, templates))
+			}
+		}
+
+		// definition, including ssh keys
+		errs := serverDefinition.Validate()
+		for index, err := range errs {
+			report.Add("definition", id+":"+strconv.Itoa(index), err)
+		}
+		if len(errs) == 0 {
+			report.Add("definition", id, nil)
+		}
+	}
+
+	report.Log()
+
+	if report.Failed() {
+		res.AddErrors(report.Errors())
+		res.AddError(errors.New("UpCloud preflight checks failed."))
+		res.MarkFailed()
+	} else {
+		res.MarkSuccess()
+	}
+
+	res.MarkFinished()
+
+	return res.Result()
+}
+
+// Check that a zone exists, and is allowed in the project
+func preflightCheckZone(id string, zones *upcloud.Zones, settings *UpcloudBuilderSettings) error {
+	if id == "" {
+		return errors.New("No zone was defined")
+	}
+	for _, zone := range zones.Zones {
+		if zone.Id == id {
+			if !settings.ZoneAllowed(zone) {
+				return errors.New("Zone is not allowed for the project: " + id)
+			}
+			return nil
+		}
+	}
+	return errors.New("Zone does not exist: " + id)
+}
+
+// Check that a plan is offered, or that a custom configuration is complete
+func preflightCheckPlan(request upcloud_request.CreateServerRequest, plans *upcloud.Plans) error {
+	if request.Plan == "" || request.Plan == "custom" {
+		if request.CoreNumber <= 0 || request.MemoryAmount <= 0 {
+			return errors.New("No plan was defined, and custom core number and memory amount are incomplete")
+		}
+		return nil
+	}
+	for _, plan := range plans.Plans {
+		if plan.Name == request.Plan {
+			return nil
+		}
+	}
+	return errors.New("Plan is not offered: " + request.Plan)
+}
+
+// Check that a storage UUID matches a template storage
+func preflightCheckTemplate(uuid string, templates *upcloud.Storages) error {
+	for _, template := range templates.Storages {
+		if template.UUID == uuid {
+			return nil
+		}
+	}
+	return errors.New("Template storage does not exist: " + uuid)
+}
+
+// A single preflight check outcome
+type preflightCheck struct {
+	check   string
+	subject string
+	err     error
+}
+
+// A collection of preflight check outcomes
+type preflightReport struct {
+	checks []preflightCheck
+}
+
+// Add a check outcome to the report, a nil error is a pass
+func (report *preflightReport) Add(check, subject string, err error) {
+	report.checks = append(report.checks, preflightCheck{check: check, subject: subject, err: err})
+}
+
+// Did any check fail?
+func (report *preflightReport) Failed() bool {
+	return len(report.Errors()) > 0
+}
+
+// Errors from the failed checks
+func (report *preflightReport) Errors() []error {
+	errs := []error{}
+	for _, check := range report.checks {
+		if check.err != nil {
+			errs = append(errs, errors.New(check.check+" ["+check.subject+"]: "+check.err.Error()))
+		}
+	}
+	return errs
+}
+
+// Log the pass/fail report
+func (report *preflightReport) Log() {
+	for _, check := range report.checks {
+		if check.err == nil {
+			log.WithFields(log.Fields{"check": check.check, "subject": check.subject}).Info("Preflight: PASS")
+		} else {
+			log.WithError(check.err).WithFields(log.Fields{"check": check.check, "subject": check.subject}).Error("Preflight: FAIL")
+		}
+	}
+}
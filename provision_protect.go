@@ -0,0 +1,190 @@
+package upcloud
+
+import (
+	"errors"
+
+	log "github.com/Sirupsen/logrus"
+
+	upcloud_request "github.com/Jalle19/upcloud-go-sdk/upcloud/request"
+
+	api_operation "github.com/wunderkraut/radi-api/operation"
+	api_property "github.com/wunderkraut/radi-api/property"
+	api_result "github.com/wunderkraut/radi-api/result"
+	api_usage "github.com/wunderkraut/radi-api/usage"
+)
+
+const (
+	// Tag marking a live server as protected from deletion
+	UPCLOUD_PROTECTED_TAG = "RADI_PROTECTED"
+)
+
+/**
+ * Deletion protection for servers
+ *
+ * A server is protected if its definition is marked Protected, or
+ * if the live server has the protection tag.  Protected servers are
+ * not deleted unless their id or UUID is passed in the confirm
+ * property, or the tag is removed with the unprotect operation.
+ */
+
+// Does a list of server tags include the protection tag?
+func hasProtectedTag(tags []string) bool {
+	for _, tag := range tags {
+		if tag == UPCLOUD_PROTECTED_TAG {
+			return true
+		}
+	}
+	return false
+}
+
+// Does the confirm property value include any of a list of server ids or UUIDs?
+func protectionConfirmed(props api_property.Properties, keys ...string) bool {
+	if confirmProp, found := props.Get(UPCLOUD_CONFIRM_PROPERTY); found {
+		for _, confirmed := range confirmProp.Get().([]string) {
+			for _, key := range keys {
+				if key != "" && confirmed == key {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// Protect servers operation
+type UpcloudServerProtectOperation struct {
+	BaseUpcloudServiceOperation
+}
+
+// Return the string machinename/id of the Operation
+func (protect *UpcloudServerProtectOperation) Id() string {
+	return "upcloud.server.protect"
+}
+
+// Return a user readable string label for the Operation
+func (protect *UpcloudServerProtectOperation) Label() string {
+	return "Protect UpCloud servers"
+}
+
+// return a multiline string description for the Operation
+func (protect *UpcloudServerProtectOperation) Description() string {
+	return "Tag UpCloud servers as protected from deletion."
+}
+
+// return a multiline string man page for the Operation
+func (protect *UpcloudServerProtectOperation) Help() string {
+	return ""
+}
+
+// Is this operation meant to be used only inside the API
+func (protect *UpcloudServerProtectOperation) Usage() api_usage.Usage {
+	return api_operation.Usage_External()
+}
+
+// Run a validation check on the Operation
+func (protect *UpcloudServerProtectOperation) Validate() api_result.Result {
+	return api_result.MakeSuccessfulResult()
+}
+
+// What settings/values does the Operation provide to an implemenentor
+func (protect *UpcloudServerProtectOperation) Properties() api_property.Properties {
+	props := api_property.New_SimplePropertiesEmpty()
+
+	props.Add(api_property.Property(&UpcloudGlobalProperty{}))
+	props.Add(api_property.Property(&UpcloudServerUUIDSProperty{}))
+
+	return props.Properties()
+}
+
+// Execute the Operation
+func (protect *UpcloudServerProtectOperation) Exec(props api_property.Properties) api_result.Result {
+	return execProtection(protect.ServiceWrapper(), props, true)
+}
+
+// Unprotect servers operation
+type UpcloudServerUnprotectOperation struct {
+	BaseUpcloudServiceOperation
+}
+
+// Return the string machinename/id of the Operation
+func (unprotect *UpcloudServerUnprotectOperation) Id() string {
+	return "upcloud.server.unprotect"
+}
+
+// Return a user readable string label for the Operation
+func (unprotect *UpcloudServerUnprotectOperation) Label() string {
+	return "Unprotect UpCloud servers"
+}
+
+// return a multiline string description for the Operation
+func (unprotect *UpcloudServerUnprotectOperation) Description() string {
+	return "Remove the deletion protection tag from UpCloud servers."
+}
+
+// return a multiline string man page for the Operation
+func (unprotect *UpcloudServerUnprotectOperation) Help() string {
+	return "Servers marked Protected in the project configuration remain protected until the configuration is changed."
+}
+
+// Is this operation meant to be used only inside the API
+func (unprotect *UpcloudServerUnprotectOperation) Usage() api_usage.Usage {
+	return api_operation.Usage_External()
+}
+
+// Run a validation check on the Operation
+func (unprotect *UpcloudServerUnprotectOperation) Validate() api_result.Result {
+	return api_result.MakeSuccessfulResult()
+}
+
+// What settings/values does the Operation provide to an implemenentor
+func (unprotect *UpcloudServerUnprotectOperation) Properties() api_property.Properties {
+	props := api_property.New_SimplePropertiesEmpty()
+
+	props.Add(api_property.Property(&UpcloudGlobalProperty{}))
+	props.Add(api_property.Property(&UpcloudServerUUIDSProperty{}))
+
+	return props.Properties()
+}
+
+// Execute the Operation
+func (unprotect *UpcloudServerUnprotectOperation) Exec(props api_property.Properties) api_result.Result {
+	return execProtection(unprotect.ServiceWrapper(), props, false)
+}
+
+// Add or remove the protection tag for the servers in the UUIDs property
+func execProtection(service *UpcloudServiceWrapper, props api_property.Properties, protected bool) api_result.Result {
+	res := api_result.New_StandardResult()
+
+	if globalProp, found := props.Get(UPCLOUD_GLOBAL_PROPERTY); found {
+		service.SetGlobal(globalProp.Get().(bool))
+	}
+	uuids := []string{}
+	if uuidsProp, found := props.Get(UPCLOUD_SERVER_UUIDS_PROPERTY); found {
+		uuids = append(uuids, uuidsProp.Get().([]string)...)
+	}
+
+	if len(uuids) == 0 {
+		log.Info("No servers requested.  You should have passed a server UUID")
+	}
+
+	for _, uuid := range uuids {
+		var err error
+		if protected {
+			_, err = service.TagServer(&upcloud_request.TagServerRequest{UUID: uuid, Tags: []string{UPCLOUD_PROTECTED_TAG}})
+		} else {
+			_, err = service.UntagServer(&upcloud_request.UntagServerRequest{UUID: uuid, Tags: []string{UPCLOUD_PROTECTED_TAG}})
+		}
+
+		if err != nil {
+			res.AddError(err)
+			res.AddError(errors.New("Could not change the protection of UpCloud server : " + uuid))
+			res.MarkFailed()
+			continue
+		}
+		log.WithFields(log.Fields{"UUID": uuid, "protected": protected}).Info("Changed UpCloud server protection")
+	}
+
+	res.MarkFinished()
+
+	return res.Result()
+}
@@ -0,0 +1,147 @@
+package upcloud
+
+import (
+	"errors"
+
+	log "github.com/Sirupsen/logrus"
+
+	upcloud_request "github.com/Jalle19/upcloud-go-sdk/upcloud/request"
+
+	api_operation "github.com/wunderkraut/radi-api/operation"
+	api_property "github.com/wunderkraut/radi-api/property"
+	api_result "github.com/wunderkraut/radi-api/result"
+	api_usage "github.com/wunderkraut/radi-api/usage"
+)
+
+/**
+ * Refresh the local provisioning state against the UpCloud API
+ */
+
+// State refresh operation
+type UpcloudProvisionRefreshOperation struct {
+	BaseUpcloudServiceOperation
+}
+
+// Return the string machinename/id of the Operation
+func (refresh *UpcloudProvisionRefreshOperation) Id() string {
+	return "upcloud.provision.refresh"
+}
+
+// Return a user readable string label for the Operation
+func (refresh *UpcloudProvisionRefreshOperation) Label() string {
+	return "Refresh UpCloud state"
+}
+
+// return a multiline string description for the Operation
+func (refresh *UpcloudProvisionRefreshOperation) Description() string {
+	return "Check the local UpCloud provisioning state against the UpCloud API."
+}
+
+// return a multiline string man page for the Operation
+func (refresh *UpcloudProvisionRefreshOperation) Help() string {
+	return ""
+}
+
+// Is this operation meant to be used only inside the API
+func (refresh *UpcloudProvisionRefreshOperation) Usage() api_usage.Usage {
+	return api_operation.Usage_External()
+}
+
+// Run a validation check on the Operation
+func (refresh *UpcloudProvisionRefreshOperation) Validate() api_result.Result {
+	return api_result.MakeSuccessfulResult()
+}
+
+// What settings/values does the Operation provide to an implemenentor
+func (refresh *UpcloudProvisionRefreshOperation) Properties() api_property.Properties {
+	return api_property.New_SimplePropertiesEmpty().Properties()
+}
+
+/**
+ * Execute the Operation
+ *
+ *   1. entries whose server no longer exists are reported and removed
+ *   2. entry storages which no longer exist are reported and removed
+ *   3. entries for servers no longer in the configuration are reported
+ *   4. configured servers found by title, but not in the state, are added
+ *
+ * Reported entries are logged as warnings, and don't fail the operation.
+ */
+func (refresh *UpcloudProvisionRefreshOperation) Exec(props api_property.Properties) api_result.Result {
+	res := api_result.New_StandardResult()
+
+	service := refresh.ServiceWrapper()
+	serverDefinitions := refresh.ServerDefinitions()
+	state := refresh.State()
+
+	servers, err := service.GetServers()
+	if err != nil {
+		res.AddError(err)
+		res.AddError(errors.New("Could not retrieve UpCloud servers, so the state was not refreshed."))
+		res.MarkFailed()
+		res.MarkFinished()
+		return res.Result()
+	}
+	storages, err := service.GetStorages(&upcloud_request.GetStoragesRequest{})
+	if err != nil {
+		res.AddError(err)
+		res.AddError(errors.New("Could not retrieve UpCloud storages, so the state was not refreshed."))
+		res.MarkFailed()
+		res.MarkFinished()
+		return res.Result()
+	}
+
+	// findings are logged, the result errors are for failures
+	for _, id := range state.Prune(state.Ids(), servers) {
+		log.WithFields(log.Fields{"id": id}).Warn("REFRESH: Server in state no longer exists, removed from state")
+	}
+
+	liveStorages := map[string]bool{}
+	for _, storage := range storages.Storages {
+		liveStorages[storage.UUID] = true
+	}
+	for _, id := range state.Ids() {
+		recorded, _ := state.Get(id)
+
+		existing := []string{}
+		for _, storage := range recorded.Storages {
+			if liveStorages[storage] {
+				existing = append(existing, storage)
+			} else {
+				log.WithFields(log.Fields{"id": id, "storage": storage}).Warn("REFRESH: Storage in state no longer exists, removed from state")
+			}
+		}
+		if len(existing) != len(recorded.Storages) {
+			recorded.Storages = existing
+			state.Set(id, recorded)
+		}
+
+		if _, found := serverDefinitions.Get(id); !found {
+			log.WithFields(log.Fields{"id": id, "UUID": recorded.UUID}).Warn("REFRESH: Server in state is no longer configured")
+		}
+	}
+
+	for _, id := range serverDefinitions.Order() {
+		if _, found := state.Get(id); found {
+			continue
+		}
+		serverDefinition, _ := serverDefinitions.Get(id)
+		if details, err := serverDefinition.GetServerDetails(); err == nil {
+			log.WithFields(log.Fields{"id": id, "UUID": details.UUID}).Info("REFRESH: Adding existing server to state")
+			storages := createdStorages(serverDefinition.CreateServerRequest(), *details)
+			state.Set(id, New_UpcloudStateServer(*details, storages, serverDefinition.GetFirewallRules()))
+		}
+	}
+
+	if err := state.Save(); err != nil {
+		res.AddError(err)
+		res.AddError(errors.New("Could not save the UpCloud state file : " + state.Path()))
+		res.MarkFailed()
+	} else {
+		res.MarkSuccess()
+	}
+
+	res.MarkFinished()
+
+	return res.Result()
+}
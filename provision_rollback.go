@@ -0,0 +1,139 @@
+package upcloud
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	upcloud "github.com/Jalle19/upcloud-go-sdk/upcloud"
+	upcloud_request "github.com/Jalle19/upcloud-go-sdk/upcloud/request"
+)
+
+const (
+	// Storage device actions of a create server request
+	UPCLOUD_STORAGE_ACTION_CREATE = "create"
+	UPCLOUD_STORAGE_ACTION_CLONE  = "clone"
+)
+
+/**
+ * Tracking of resources created during a provisioning run, so
+ * that they can be removed if the run fails part way through.
+ */
+
+// A resource created during a provisioning run
+type provisionedResource struct {
+	id       string
+	uuid     string
+	storages []string
+}
+
+// A record of all resources created during a provisioning run
+type provisionTransaction struct {
+	resources []provisionedResource
+}
+
+// Record a newly created server, with the storages that were created for it
+func (transaction *provisionTransaction) AddServer(id string, details upcloud.ServerDetails, storages []string) {
+	transaction.resources = append(transaction.resources, provisionedResource{
+		id:       id,
+		uuid:     details.UUID,
+		storages: storages,
+	})
+}
+
+/**
+ * The storages that a create server request made for the server
+ *
+ * Only create and clone actions make new storages.  Storages that
+ * were attached by UUID already existed, and CD-ROMs are shared, so
+ * neither are ever counted as created, and so they are never removed.
+ */
+func createdStorages(request upcloud_request.CreateServerRequest, details upcloud.ServerDetails) []string {
+	storages := []string{}
+
+	creates := false
+	attached := map[string]bool{}
+	for _, device := range request.StorageDevices {
+		switch strings.ToLower(device.Action) {
+		case UPCLOUD_STORAGE_ACTION_CREATE, UPCLOUD_STORAGE_ACTION_CLONE:
+			creates = true
+		default:
+			attached[device.Storage] = true
+		}
+	}
+	if !creates {
+		return storages
+	}
+
+	for _, device := range details.StorageDevices {
+		if device.UUID == "" || device.Type == upcloud.StorageTypeCDROM || attached[device.UUID] {
+			continue
+		}
+		storages = append(storages, device.UUID)
+	}
+	return storages
+}
+
+// Is there anything recorded?
+func (transaction *provisionTransaction) Empty() bool {
+	return len(transaction.resources) == 0
+}
+
+/**
+ * Remove all recorded resources, in reverse order of creation
+ *
+ * Each server is stopped, deleted, and then its storages are deleted.
+ * The timeout is used for each wait for a server to stop.
+ * A list of rolled back resources is returned, along with any errors
+ * for resources that could not be removed.
+ */
+func (transaction *provisionTransaction) Rollback(service *UpcloudServiceWrapper, timeout time.Duration) ([]string, []error) {
+	rolledBack := []string{}
+	errs := []error{}
+
+	for index := len(transaction.resources) - 1; index >= 0; index-- {
+		resource := transaction.resources[index]
+		logger := log.WithFields(log.Fields{"id": resource.id, "UUID": resource.uuid})
+
+		if details, err := service.GetServerDetails(&upcloud_request.GetServerDetailsRequest{UUID: resource.uuid}); err != nil {
+			errs = append(errs, err)
+			errs = append(errs, errors.New("Rollback could not find server "+resource.id+" : "+resource.uuid))
+			continue
+		} else if details.State != upcloud.ServerStateStopped {
+			logger.Info("Rollback: stopping server")
+			if details.State == upcloud.ServerStateMaintenance {
+				service.WaitForServerState(&upcloud_request.WaitForServerStateRequest{UUID: resource.uuid, UndesiredState: upcloud.ServerStateMaintenance, Timeout: timeout})
+			}
+			if _, err := service.StopServer(&upcloud_request.StopServerRequest{UUID: resource.uuid, StopType: upcloud_request.ServerStopTypeHard, Timeout: timeout}); err != nil {
+				logger.WithError(err).Warn("Rollback: server failed to stop")
+			}
+			if _, err := service.WaitForServerState(&upcloud_request.WaitForServerStateRequest{UUID: resource.uuid, DesiredState: upcloud.ServerStateStopped, Timeout: timeout}); err != nil {
+				errs = append(errs, err)
+				errs = append(errs, errors.New("Rollback could not stop server "+resource.id+" : "+resource.uuid))
+				continue
+			}
+		}
+
+		if err := service.DeleteServer(&upcloud_request.DeleteServerRequest{UUID: resource.uuid}); err != nil {
+			errs = append(errs, err)
+			errs = append(errs, errors.New("Rollback could not delete server "+resource.id+" : "+resource.uuid))
+			continue
+		}
+		logger.Info("Rollback: deleted server")
+		rolledBack = append(rolledBack, "server "+resource.id+" : "+resource.uuid)
+
+		for _, storage := range resource.storages {
+			if err := service.DeleteStorage(&upcloud_request.DeleteStorageRequest{UUID: storage}); err != nil {
+				errs = append(errs, err)
+				errs = append(errs, errors.New("Rollback could not delete storage "+storage+" of server "+resource.id))
+				continue
+			}
+			logger.WithFields(log.Fields{"storage": storage}).Info("Rollback: deleted storage")
+			rolledBack = append(rolledBack, "storage "+resource.id+" : "+storage)
+		}
+	}
+
+	return rolledBack, errs
+}
@@ -0,0 +1,255 @@
+package upcloud
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+
+	api_operation "github.com/wunderkraut/radi-api/operation"
+	api_property "github.com/wunderkraut/radi-api/property"
+	api_result "github.com/wunderkraut/radi-api/result"
+	api_usage "github.com/wunderkraut/radi-api/usage"
+)
+
+/**
+ * Scaling of replica groups
+ */
+
+// Replica group scale operation
+type UpcloudProvisionScaleOperation struct {
+	BaseUpcloudServiceOperation
+}
+
+// Return the string machinename/id of the Operation
+func (scale *UpcloudProvisionScaleOperation) Id() string {
+	return "upcloud.provision.scale"
+}
+
+// Return a user readable string label for the Operation
+func (scale *UpcloudProvisionScaleOperation) Label() string {
+	return "Scale UpCloud replicas"
+}
+
+// return a multiline string description for the Operation
+func (scale *UpcloudProvisionScaleOperation) Description() string {
+	return "Change the number of servers in a replica group."
+}
+
+// return a multiline string man page for the Operation
+func (scale *UpcloudProvisionScaleOperation) Help() string {
+	return `The new count is kept in the local state, and overrides the Count in the
+project configuration, until that Count is changed.`
+}
+
+// Is this operation meant to be used only inside the API
+func (scale *UpcloudProvisionScaleOperation) Usage() api_usage.Usage {
+	return api_operation.Usage_External()
+}
+
+// Run a validation check on the Operation
+func (scale *UpcloudProvisionScaleOperation) Validate() api_result.Result {
+	return api_result.MakeSuccessfulResult()
+}
+
+// What settings/values does the Operation provide to an implemenentor
+func (scale *UpcloudProvisionScaleOperation) Properties() api_property.Properties {
+	props := api_property.New_SimplePropertiesEmpty()
+
+	props.Add(api_property.Property(&UpcloudGroupProperty{}))
+	props.Add(api_property.Property(&UpcloudCountProperty{}))
+	props.Add(api_property.Property(&UpcloudTimeoutProperty{}))
+	props.Add(api_property.Property(&UpcloudConfirmProperty{}))
+
+	return props.Properties()
+}
+
+/**
+ * Execute the Operation
+ *
+ * Missing replicas are provisioned as in provision up, and replicas
+ * numbered higher than the count are stopped and deleted, highest
+ * first.  Protected replicas are only deleted if confirmed.
+ *
+ * Scaled counts of groups whose Count has since changed in the
+ * configuration are dropped from the state.
+ */
+func (scale *UpcloudProvisionScaleOperation) Exec(props api_property.Properties) api_result.Result {
+	res := New_UpcloudCommandResult()
+
+	service := scale.ServiceWrapper()
+	state := scale.State()
+
+	group := ""
+	if groupProp, found := props.Get(UPCLOUD_GROUP_PROPERTY); found {
+		group = groupProp.Get().(string)
+	}
+	count := -1
+	if countProp, found := props.Get(UPCLOUD_COUNT_PROPERTY); found {
+		if value, err := strconv.Atoi(countProp.Get().(string)); err == nil && value >= 0 {
+			count = value
+		}
+	}
+	if group == "" || count < 0 {
+		res.AddError(errors.New("Scaling needs a replica group and a count of zero or more."))
+		res.MarkFailed()
+		res.MarkFinished()
+		return res.Result()
+	}
+
+	// the group must be a configured replica group
+	configured, known := scale.ReplicaGroups()[group]
+	if !known {
+		res.AddError(errors.New("Unknown replica group, only servers with a Count can be scaled : " + group))
+		res.MarkFailed()
+		res.MarkFinished()
+		return res.Result()
+	}
+
+	// scaled counts are only used while the configured count is unchanged, so drop any which are stale
+	if dropped := state.PruneScale(scale.ReplicaGroups()); len(dropped) > 0 {
+		log.WithFields(log.Fields{"groups": dropped}).Warn("SCALE: The Count of scaled replica groups has changed in the configuration, so their scaled counts are dropped")
+	}
+
+	log.WithFields(log.Fields{"group": group, "count": count, "configured": configured}).Info("SCALE: Scaling replica group")
+	state.SetScale(group, count, configured)
+
+	// provision the missing replicas
+	missing := ServerDefinitions{}
+	serverDefinitions := scale.ServerDefinitions()
+	for _, id := range serverDefinitions.Order() {
+		serverDefinition, _ := serverDefinitions.Get(id)
+		if serverDefinition.Group() == group && !serverDefinition.IsCreated() {
+			missing.Add(serverDefinition)
+		}
+	}
+	if len(missing.Order()) > 0 {
+		log.WithFields(log.Fields{"group": group, "ids": missing.Order()}).Info("SCALE: Creating missing replicas")
+
+		upOp := UpcloudProvisionUpOperation{BaseUpcloudServiceOperation: scale.BaseUpcloudServiceOperation, serverDefinitions: &missing}
+		upProperties := upOp.Properties()
+		if timeoutProp, found := props.Get(UPCLOUD_TIMEOUT_PROPERTY); found {
+			if upTimeoutProp, found := upProperties.Get(UPCLOUD_TIMEOUT_PROPERTY); found {
+				upTimeoutProp.Set(timeoutProp.Get())
+			}
+		}
+
+		upResult := upOp.Exec(upProperties)
+		<-upResult.Finished()
+
+		res.Merge(upResult)
+		if outputs, ok := upResult.(UpcloudCommandOutputs); ok {
+			res.AddOutputs(outputs.Outputs())
+		}
+	}
+
+	// remove the extra replicas, highest numbered first
+	servers, err := service.GetServers()
+	if err != nil {
+		res.AddError(err)
+		res.AddError(errors.New("Could not retrieve UpCloud servers to find extra replicas."))
+		res.MarkFailed()
+		res.MarkFinished()
+		return res.Result()
+	}
+	// servers of definitions outside of the group, like a server with the id "web-2", are never replicas
+	owned := map[string]bool{}
+	for _, id := range serverDefinitions.Order() {
+		serverDefinition, _ := serverDefinitions.Get(id)
+		if serverDefinition.Group() == group {
+			continue
+		}
+		if uuid, err := serverDefinition.UUID(); err == nil {
+			owned[uuid] = true
+		}
+		if recorded, found := state.Get(id); found {
+			owned[recorded.UUID] = true
+		}
+	}
+	extras := map[int]string{}
+	indexes := []int{}
+	for _, server := range servers.Servers {
+		if owned[server.UUID] {
+			continue
+		}
+		if index, found := replicaIndex(server.Title, group); found && index > count {
+			extras[index] = server.UUID
+			indexes = append(indexes, index)
+		}
+	}
+	if len(indexes) > 0 {
+		uuids := []string{}
+		ids := []string{}
+		for len(indexes) > 0 {
+			highest := 0
+			for position, index := range indexes {
+				if index > indexes[highest] {
+					highest = position
+				}
+			}
+			uuids = append(uuids, extras[indexes[highest]])
+			ids = append(ids, group+"-"+strconv.Itoa(indexes[highest]))
+			indexes = append(indexes[:highest], indexes[highest+1:]...)
+		}
+		log.WithFields(log.Fields{"group": group, "ids": ids}).Info("SCALE: Removing extra replicas")
+
+		deleteOp := UpcloudServerDeleteOperation{BaseUpcloudServiceOperation: scale.BaseUpcloudServiceOperation}
+		deleteProperties := deleteOp.Properties()
+		if uuidsProp, found := deleteProperties.Get(UPCLOUD_SERVER_UUIDS_PROPERTY); found {
+			uuidsProp.Set(uuids)
+		}
+		if forceProp, found := deleteProperties.Get(UPCLOUD_FORCE_PROPERTY); found {
+			forceProp.Set(true)
+		}
+		for _, key := range []string{UPCLOUD_TIMEOUT_PROPERTY, UPCLOUD_CONFIRM_PROPERTY} {
+			if scaleProp, found := props.Get(key); found {
+				if deleteProp, found := deleteProperties.Get(key); found {
+					deleteProp.Set(scaleProp.Get())
+				}
+			}
+		}
+
+		deleteResult := deleteOp.Exec(deleteProperties)
+		<-deleteResult.Finished()
+
+		res.Merge(deleteResult)
+		if !deleteResult.Success() {
+			res.MarkFailed()
+		}
+
+		if servers, err := service.GetServers(); err == nil {
+			state.Prune(ids, servers)
+		} else {
+			res.AddError(err)
+		}
+	}
+
+	if err := state.Save(); err != nil {
+		res.AddError(err)
+		res.AddError(errors.New("Could not save the UpCloud state file : " + state.Path()))
+		res.MarkFailed()
+	}
+
+	res.MarkFinished()
+
+	return res.Result()
+}
+
+// The replica index of a server title, if the server is a replica in a group
+func replicaIndex(title string, group string) (int, bool) {
+	prefix := "KRAUT:" + group + "-"
+	if !strings.HasPrefix(title, prefix) {
+		return 0, false
+	}
+	rest := strings.TrimPrefix(title, prefix)
+	end := strings.Index(rest, ":")
+	if end < 1 {
+		return 0, false
+	}
+	index, err := strconv.Atoi(rest[:end])
+	if err != nil || index < 1 {
+		return 0, false
+	}
+	return index, true
+}
@@ -2,7 +2,6 @@ package upcloud
 
 import (
 	"errors"
-	"time"
 
 	log "github.com/Sirupsen/logrus"
 
@@ -48,6 +47,9 @@ func (server *UpcloudServerHandler) Operations() api_operation.Operations {
 	ops.Add(api_operation.Operation(&UpcloudServerCreateOperation{BaseUpcloudServiceOperation: *baseOperation}))
 	ops.Add(api_operation.Operation(&UpcloudServerStopOperation{BaseUpcloudServiceOperation: *baseOperation}))
 	ops.Add(api_operation.Operation(&UpcloudServerDeleteOperation{BaseUpcloudServiceOperation: *baseOperation}))
+	ops.Add(api_operation.Operation(&UpcloudServerProtectOperation{BaseUpcloudServiceOperation: *baseOperation}))
+	ops.Add(api_operation.Operation(&UpcloudServerUnprotectOperation{BaseUpcloudServiceOperation: *baseOperation}))
+	ops.Add(api_operation.Operation(&UpcloudServerExecOperation{BaseUpcloudServiceOperation: *baseOperation}))
 
 	return ops.Operations()
 }
@@ -114,10 +116,8 @@ func (create *UpcloudServerCreateOperation) Exec(props api_property.Properties)
 	service := create.ServiceWrapper()
 	// settings := create.BuilderSettings()
 
-	properties := create.Properties()
-
 	request := upcloud_request.CreateServerRequest{}
-	if requestProp, found := properties.Get(UPCLOUD_SERVER_CREATEREQUEST_PROPERTY); found {
+	if requestProp, found := props.Get(UPCLOUD_SERVER_CREATEREQUEST_PROPERTY); found {
 		request = requestProp.Get().(upcloud_request.CreateServerRequest)
 		log.WithFields(log.Fields{"key": UPCLOUD_SERVER_CREATEREQUEST_PROPERTY, "prop": requestProp, "value": request}).Debug("Retrieved create server request")
 	}
@@ -126,13 +126,14 @@ func (create *UpcloudServerCreateOperation) Exec(props api_property.Properties)
 	serverDetails, err := service.CreateServer(&request)
 
 	if err == nil {
-		if detailsProp, found := properties.Get(UPCLOUD_SERVER_DETAILS_PROPERTY); found {
+		if detailsProp, found := props.Get(UPCLOUD_SERVER_DETAILS_PROPERTY); found {
 			detailsProp.Set(*serverDetails)
 		}
 		log.WithFields(log.Fields{"UUID": serverDetails.UUID}).Debug("server: Server created")
 
 		res.MarkSuccess()
 	} else {
+		res.AddError(err)
 		res.AddError(errors.New("Unable to provision new server."))
 		res.MarkFailed()
 	}
@@ -329,7 +330,9 @@ func (delete *UpcloudServerDeleteOperation) Properties() api_property.Properties
 
 	props.Add(api_property.Property(&UpcloudWaitProperty{}))
 	props.Add(api_property.Property(&UpcloudForceProperty{}))
+	props.Add(api_property.Property(&UpcloudTimeoutProperty{}))
 	props.Add(api_property.Property(&UpcloudServerUUIDSProperty{}))
+	props.Add(api_property.Property(&UpcloudConfirmProperty{}))
 
 	return props.Properties()
 }
@@ -339,6 +342,9 @@ func (delete *UpcloudServerDeleteOperation) Properties() api_property.Properties
  * @NOTE this is a first version.
  * @TODO this is a prime candidate for goroutines now that we have threaded options
  *
+ * Servers with the protection tag are skipped, unless their UUID
+ * is passed in the confirm property.
+ *
  * We will want to :
  *  1. retrieve servers by tag
  *  2. have a "remove-specific-uuid" option?
@@ -347,27 +353,29 @@ func (delete *UpcloudServerDeleteOperation) Exec(props api_property.Properties)
 	res := api_result.New_StandardResult()
 
 	service := delete.ServiceWrapper()
-	settings := delete.BuilderSettings()
 
-	properties := delete.Properties()
+	stopTimeout := delete.Timeout(UPCLOUD_TIMEOUT_STOP, props)
+	deleteTimeout := delete.Timeout(UPCLOUD_TIMEOUT_DELETE, props)
 
 	global := false
-	if globalProp, found := properties.Get(UPCLOUD_GLOBAL_PROPERTY); found {
+	if globalProp, found := props.Get(UPCLOUD_GLOBAL_PROPERTY); found {
 		global = globalProp.Get().(bool)
 		log.WithFields(log.Fields{"key": UPCLOUD_GLOBAL_PROPERTY, "prop": globalProp, "value": global}).Debug("DELETE: Allowing global access")
 	}
+	// the service refuses to touch servers outside of the project, unless global
+	service.SetGlobal(global)
 	wait := false
-	if waitProp, found := properties.Get(UPCLOUD_WAIT_PROPERTY); found {
+	if waitProp, found := props.Get(UPCLOUD_WAIT_PROPERTY); found {
 		wait = waitProp.Get().(bool)
 		log.WithFields(log.Fields{"key": UPCLOUD_WAIT_PROPERTY, "prop": waitProp, "value": wait}).Debug("DELETE: Wait for operation to complete")
 	}
 	force := false
-	if waitProp, found := properties.Get(UPCLOUD_FORCE_PROPERTY); found {
+	if waitProp, found := props.Get(UPCLOUD_FORCE_PROPERTY); found {
 		force = waitProp.Get().(bool)
 		log.WithFields(log.Fields{"key": UPCLOUD_FORCE_PROPERTY, "prop": waitProp, "value": force}).Debug("DELETE: force operation activated.")
 	}
 	uuidMatch := []string{}
-	if uuidsProp, found := properties.Get(UPCLOUD_SERVER_UUIDS_PROPERTY); found {
+	if uuidsProp, found := props.Get(UPCLOUD_SERVER_UUIDS_PROPERTY); found {
 		newUUIDs := uuidsProp.Get().([]string)
 		uuidMatch = append(uuidMatch, newUUIDs...)
 		log.WithFields(log.Fields{"key": UPCLOUD_SERVER_UUIDS_PROPERTY, "prop": uuidsProp, "value": uuidMatch}).Debug("DELETE: Filter Server UUID")
@@ -377,11 +385,6 @@ func (delete *UpcloudServerDeleteOperation) Exec(props api_property.Properties)
 
 		count := 0
 		for _, uuid := range uuidMatch {
-			if !(global || settings.ServerUUIDAllowed(uuid)) {
-				log.WithFields(log.Fields{"uuid": uuid}).Error("Server UUID not a part of the project. Details will not be shown.")
-				continue
-			}
-
 			details, err := service.GetServerDetails(&upcloud_request.GetServerDetailsRequest{UUID: uuid})
 
 			if err != nil {
@@ -391,17 +394,28 @@ func (delete *UpcloudServerDeleteOperation) Exec(props api_property.Properties)
 				continue
 			}
 
+			if hasProtectedTag(details.Tags) && !protectionConfirmed(props, uuid) {
+				log.WithFields(log.Fields{"UUID": uuid, "title": details.Title}).Warn("UpCloud server is protected, so it will not be deleted.")
+				res.AddError(errors.New("Skipped protected server, confirm or unprotect it to delete it : " + uuid))
+				res.MarkFailed()
+				continue
+			}
+
 			if force && details.State == upcloud.ServerStateStarted {
 				log.WithFields(log.Fields{"UUID": uuid, "state": details.State}).Warn("Stopping UpCloud server before deleting it.")
 				_, err := service.StopServer(&upcloud_request.StopServerRequest{
 					UUID:     details.UUID,
 					StopType: upcloud_request.ServerStopTypeHard,
-					Timeout:  time.Minute * 2,
+					Timeout:  stopTimeout,
 				})
-				if err != nil {
+				if IsUpcloudScopeError(err) {
+					res.AddError(err)
+					res.MarkFailed()
+					continue
+				} else if err != nil {
 					log.WithFields(log.Fields{"UUID": uuid}).Warn("UpCloud server failed to stop before being deleted.")
 					continue
-				} else if waitDetails, err := service.WaitForServerState(&upcloud_request.WaitForServerStateRequest{UUID: uuid, DesiredState: upcloud.ServerStateStopped, Timeout: time.Minute * 2}); err != nil {
+				} else if waitDetails, err := service.WaitForServerState(&upcloud_request.WaitForServerStateRequest{UUID: uuid, DesiredState: upcloud.ServerStateStopped, Timeout: stopTimeout}); err != nil {
 					log.WithFields(log.Fields{"UUID": uuid, "state": waitDetails.State}).Warn("UpCloud server failed to stop before being deleted.")
 				}
 			}
@@ -416,7 +430,7 @@ func (delete *UpcloudServerDeleteOperation) Exec(props api_property.Properties)
 					waitRequest := upcloud_request.WaitForServerStateRequest{
 						UUID:         uuid,
 						DesiredState: "stopped",
-						Timeout:      time.Duration(60) * time.Second,
+						Timeout:      deleteTimeout,
 					}
 					details, err := service.WaitForServerState(&waitRequest)
 
@@ -490,6 +504,7 @@ func (stop *UpcloudServerStopOperation) Properties() api_property.Properties {
 
 	props.Add(api_property.Property(&UpcloudGlobalProperty{}))
 	props.Add(api_property.Property(&UpcloudWaitProperty{}))
+	props.Add(api_property.Property(&UpcloudTimeoutProperty{}))
 	props.Add(api_property.Property(&UpcloudServerUUIDSProperty{}))
 
 	return props.Properties()
@@ -503,13 +518,17 @@ func (stop *UpcloudServerStopOperation) Exec(props api_property.Properties) api_
 	res := api_result.New_StandardResult()
 
 	service := stop.ServiceWrapper()
-	settings := stop.BuilderSettings()
+	// settings := stop.BuilderSettings()
+
+	stopTimeout := stop.Timeout(UPCLOUD_TIMEOUT_STOP, props)
 
 	global := false
 	if globalProp, found := props.Get(UPCLOUD_GLOBAL_PROPERTY); found {
 		global = globalProp.Get().(bool)
 		log.WithFields(log.Fields{"key": UPCLOUD_GLOBAL_PROPERTY, "prop": globalProp, "value": global}).Debug("Allowing global access")
 	}
+	// the service refuses to touch servers outside of the project, unless global
+	service.SetGlobal(global)
 	wait := false
 	if waitProp, found := props.Get(UPCLOUD_WAIT_PROPERTY); found {
 		wait = waitProp.Get().(bool)
@@ -526,11 +545,6 @@ func (stop *UpcloudServerStopOperation) Exec(props api_property.Properties) api_
 
 		count := 0
 		for _, uuid := range uuidMatch {
-			if !(global || settings.ServerUUIDAllowed(uuid)) {
-				log.WithFields(log.Fields{"uuid": uuid}).Error("Server UUID not a part of the project. Details will not be shown.")
-				continue
-			}
-
 			request := upcloud_request.StopServerRequest{
 				UUID: uuid,
 			}
@@ -545,7 +559,7 @@ func (stop *UpcloudServerStopOperation) Exec(props api_property.Properties) api_
 						UUID:           uuid,
 						DesiredState:   "stopped",
 						UndesiredState: "started",
-						Timeout:        time.Duration(60) * time.Second,
+						Timeout:        stopTimeout,
 					}
 					details, err = service.WaitForServerState(&waitRequest)
 
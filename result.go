@@ -0,0 +1,84 @@
+package upcloud
+
+import (
+	api_result "github.com/wunderkraut/radi-api/result"
+)
+
+/**
+ * Operation results which carry the output of commands run on
+ * project servers, and the resources rolled back by a failed run,
+ * so that API callers get more than the log.
+ *
+ * Callers can check for the outputs with:
+ *
+ *   if outputs, ok := result.(UpcloudCommandOutputs); ok { ... }
+ *
+ * and for the rolled back resources with:
+ *
+ *   if rollback, ok := result.(UpcloudRolledBackResources); ok { ... }
+ */
+
+// The output of a command, or hook, run on a server
+type UpcloudCommandOutput struct {
+	Id       string
+	UUID     string
+	Host     string
+	Command  string
+	ExitCode int
+	Stdout   string
+	Stderr   string
+	// the error, if the command could not be run or did not exit with 0
+	Err error
+}
+
+// A result that carries command outputs
+type UpcloudCommandOutputs interface {
+	Outputs() []UpcloudCommandOutput
+}
+
+// A result that carries the resources rolled back by a failed run
+type UpcloudRolledBackResources interface {
+	RolledBack() []string
+}
+
+// Constructor for UpcloudCommandResult
+func New_UpcloudCommandResult() *UpcloudCommandResult {
+	return &UpcloudCommandResult{
+		StandardResult: api_result.New_StandardResult(),
+		outputs:        []UpcloudCommandOutput{},
+		rolledBack:     []string{},
+	}
+}
+
+// A standard result, with the outputs of commands run on servers
+type UpcloudCommandResult struct {
+	*api_result.StandardResult
+
+	outputs    []UpcloudCommandOutput
+	rolledBack []string
+}
+
+// Add the outputs of commands
+func (result *UpcloudCommandResult) AddOutputs(outputs []UpcloudCommandOutput) {
+	result.outputs = append(result.outputs, outputs...)
+}
+
+// The outputs of the commands, in the order they were run
+func (result *UpcloudCommandResult) Outputs() []UpcloudCommandOutput {
+	return result.outputs
+}
+
+// Add resources which were rolled back
+func (result *UpcloudCommandResult) AddRolledBack(resources []string) {
+	result.rolledBack = append(result.rolledBack, resources...)
+}
+
+// The rolled back resources, in the order they were removed
+func (result *UpcloudCommandResult) RolledBack() []string {
+	return result.rolledBack
+}
+
+// Return the result, keeping the outputs and rolled back resources
+func (result *UpcloudCommandResult) Result() api_result.Result {
+	return result
+}
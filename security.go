@@ -57,7 +57,7 @@ func (securityUser *UpcloudSecurityUserOperation) Label() string {
 
 // return a multiline string description for the Operation
 func (securityUser *UpcloudSecurityUserOperation) Description() string {
-	return "Show information about the current UpCloud account."
+	return "Show information about the current UpCloud account, and check that it has enough credits."
 }
 
 // return a multiline string man page for the Operation
@@ -77,19 +77,68 @@ func (securityUser *UpcloudSecurityUserOperation) Validate() api_result.Result {
 
 // What settings/values does the Operation provide to an implemenentor
 func (securityUser *UpcloudSecurityUserOperation) Properties() api_property.Properties {
-	return api_property.New_SimplePropertiesEmpty().Properties()
+	props := api_property.New_SimplePropertiesEmpty()
+
+	props.Add(api_property.Property(&UpcloudAccountProperty{}))
+
+	return props.Properties()
 }
 
-// Execute the Operation
+/**
+ * Execute the Operation
+ *
+ * The account credits are compared to the credit thresholds from the
+ * builder settings.  Falling below a minimum fails the operation, and
+ * falling below a warning level logs a warning, and still succeeds.
+ *
+ * Days remaining is estimated from the cost of the project servers that
+ * are currently created.
+ */
 func (securityUser *UpcloudSecurityUserOperation) Exec(props api_property.Properties) api_result.Result {
 	res := api_result.New_StandardResult()
 
 	service := securityUser.ServiceWrapper()
+	settings := securityUser.BuilderSettings()
+	serverDefinitions := securityUser.ServerDefinitions()
 
 	account, err := service.GetAccount()
 	if err == nil {
-		log.WithFields(log.Fields{"username": account.UserName, "credits": account.Credits}).Info("Current UpCloud Account")
-		res.MarkSuccess()
+		if accountProp, found := props.Get(UPCLOUD_ACCOUNT_PROPERTY); found {
+			accountProp.Set(*account)
+		}
+
+		// estimate the project burn rate from the servers that exist
+		createdDefinitions := ServerDefinitions{}
+		for _, id := range serverDefinitions.Order() {
+			serverDefinition, _ := serverDefinitions.Get(id)
+			if serverDefinition.IsCreated() {
+				createdDefinitions.Add(serverDefinition)
+			}
+		}
+		hourly := 0.0
+		if estimate, err := estimateProjectCost(service, &createdDefinitions); err == nil {
+			hourly = estimate.Hourly()
+		} else {
+			log.WithError(err).Warn("Could not estimate project burn rate.")
+		}
+		days := -1.0
+		if hourly > 0 {
+			days = account.Credits / (hourly * 24)
+		}
+
+		log.WithFields(log.Fields{"username": account.UserName, "credits": account.Credits, "hourly": formatCredits(hourly), "days": formatCredits(days)}).Info("Current UpCloud Account")
+
+		if err := settings.Credits.CheckMinimum(account.Credits, days); err != nil {
+			log.WithError(err).Error("UpCloud account credits are too low")
+			res.AddError(err)
+			res.MarkFailed()
+		} else {
+			// a warning is only logged, the result errors are for failures
+			if err := settings.Credits.CheckWarning(account.Credits, days); err != nil {
+				log.WithError(err).Warn("UpCloud account credits are low")
+			}
+			res.MarkSuccess()
+		}
 	} else {
 		res.AddError(err)
 		res.AddError(errors.New("Could not retrieve UpCloud account information."))
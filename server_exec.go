@@ -0,0 +1,280 @@
+package upcloud
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+
+	api_operation "github.com/wunderkraut/radi-api/operation"
+	api_property "github.com/wunderkraut/radi-api/property"
+	api_result "github.com/wunderkraut/radi-api/result"
+	api_usage "github.com/wunderkraut/radi-api/usage"
+)
+
+const (
+	// How many servers a command is run on at the same time
+	UPCLOUD_EXEC_PARALLEL = 10
+)
+
+/**
+ * Running a command on project servers
+ */
+
+// Server command execution operation
+type UpcloudServerExecOperation struct {
+	BaseUpcloudServiceOperation
+}
+
+// Return the string machinename/id of the Operation
+func (exec *UpcloudServerExecOperation) Id() string {
+	return "upcloud.server.exec"
+}
+
+// Return a user readable string label for the Operation
+func (exec *UpcloudServerExecOperation) Label() string {
+	return "Run a command on UpCloud servers"
+}
+
+// return a multiline string description for the Operation
+func (exec *UpcloudServerExecOperation) Description() string {
+	return "Run a shell command over ssh on the project servers."
+}
+
+// return a multiline string man page for the Operation
+func (exec *UpcloudServerExecOperation) Help() string {
+	return `Servers can be selected by server id (or replica group), by UpCloud tag,
+and by role.  Without any filter, the command is run on all provisioned
+project servers.  The exit code, stdout and stderr of each server are
+kept in the operation result.`
+}
+
+// Is this operation meant to be used only inside the API
+func (exec *UpcloudServerExecOperation) Usage() api_usage.Usage {
+	return api_operation.Usage_External()
+}
+
+// Run a validation check on the Operation
+func (exec *UpcloudServerExecOperation) Validate() api_result.Result {
+	return api_result.MakeSuccessfulResult()
+}
+
+// What settings/values does the Operation provide to an implemenentor
+func (exec *UpcloudServerExecOperation) Properties() api_property.Properties {
+	props := api_property.New_SimplePropertiesEmpty()
+
+	props.Add(api_property.Property(&UpcloudCommandProperty{}))
+	props.Add(api_property.Property(&UpcloudServerIdsProperty{}))
+	props.Add(api_property.Property(&UpcloudTagProperty{}))
+	props.Add(api_property.Property(&UpcloudRoleProperty{}))
+	props.Add(api_property.Property(&UpcloudFailFastProperty{}))
+
+	return props.Properties()
+}
+
+// A project server that a command is run on
+type execTarget struct {
+	id     string
+	uuid   string
+	target sshRunner
+}
+
+// The outcome of running a command on one server
+type execOutcome struct {
+	execTarget
+	result  sshResult
+	err     error
+	skipped bool
+}
+
+/**
+ * Execute the Operation
+ *
+ * The command is run on up to UPCLOUD_EXEC_PARALLEL servers at
+ * a time.  By default it is run on every selected server; in fail
+ * fast mode no more servers are started after the first failure.
+ */
+func (exec *UpcloudServerExecOperation) Exec(props api_property.Properties) api_result.Result {
+	res := New_UpcloudCommandResult()
+
+	settings := exec.BuilderSettings()
+	serverDefinitions := exec.ServerDefinitions()
+
+	command := ""
+	if commandProp, found := props.Get(UPCLOUD_COMMAND_PROPERTY); found {
+		command = commandProp.Get().(string)
+	}
+	ids := []string{}
+	if idsProp, found := props.Get(UPCLOUD_SERVER_IDS_PROPERTY); found {
+		ids = idsProp.Get().([]string)
+	}
+	tag := ""
+	if tagProp, found := props.Get(UPCLOUD_TAG_PROPERTY); found {
+		tag = tagProp.Get().(string)
+	}
+	role := ""
+	if roleProp, found := props.Get(UPCLOUD_ROLE_PROPERTY); found {
+		role = roleProp.Get().(string)
+	}
+	failFast := false
+	if failFastProp, found := props.Get(UPCLOUD_FAILFAST_PROPERTY); found {
+		failFast = failFastProp.Get().(bool)
+	}
+	log.WithFields(log.Fields{"command": command, "ids": ids, "tag": tag, "role": role, "failfast": failFast}).Debug("EXEC: Settings")
+
+	if command == "" {
+		res.AddError(errors.New("No command was given to run on the servers."))
+		res.MarkFailed()
+		res.MarkFinished()
+		return res.Result()
+	}
+
+	// resolve the selected servers to ssh targets
+	targets := []execTarget{}
+	for _, id := range serverDefinitions.Order() {
+		serverDefinition, _ := serverDefinitions.Get(id)
+		if len(ids) > 0 && !execIdSelected(ids, id, serverDefinition.Group()) {
+			continue
+		}
+		if role != "" && !execHasValue(serverDefinition.Roles(), role) {
+			continue
+		}
+		if !serverDefinition.IsCreated() {
+			if len(ids) > 0 {
+				res.AddError(errors.New("Selected server has not been provisioned : " + id))
+				res.MarkFailed()
+			}
+			continue
+		}
+		details, err := serverDefinition.GetServerDetails()
+		if err != nil {
+			res.AddError(err)
+			res.AddError(errors.New("Could not retrieve server details : " + id))
+			res.MarkFailed()
+			continue
+		}
+		if tag != "" && !execHasValue(details.Tags, tag) {
+			continue
+		}
+
+		user := ""
+		if request := serverDefinition.CreateServerRequest(); request.LoginUser != nil {
+			user = request.LoginUser.Username
+		}
+		target, err := newServerSSHTarget(settings.SSH, details, user)
+		if err != nil {
+			res.AddError(err)
+			res.MarkFailed()
+			continue
+		}
+		targets = append(targets, execTarget{id: id, uuid: details.UUID, target: target})
+	}
+
+	if len(targets) == 0 {
+		res.AddError(errors.New("No provisioned servers matched the selection."))
+		res.MarkFailed()
+		res.MarkFinished()
+		return res.Result()
+	}
+
+	outcomes := runExec(command, targets, failFast)
+
+	failed := 0
+	skipped := 0
+	for _, outcome := range outcomes {
+		logger := log.WithFields(log.Fields{"id": outcome.id, "UUID": outcome.uuid, "host": outcome.target.Host()})
+		if !outcome.skipped {
+			res.AddOutputs([]UpcloudCommandOutput{{
+				Id:       outcome.id,
+				UUID:     outcome.uuid,
+				Host:     outcome.target.Host(),
+				Command:  command,
+				ExitCode: outcome.result.ExitCode,
+				Stdout:   outcome.result.Stdout,
+				Stderr:   outcome.result.Stderr,
+				Err:      outcome.err,
+			}})
+		}
+		switch {
+		case outcome.skipped:
+			skipped++
+			logger.Warn("SKIP: Command not run after an earlier failure")
+		case outcome.err != nil:
+			failed++
+			logger.WithError(outcome.err).WithFields(log.Fields{"exit": outcome.result.ExitCode, "stdout": outcome.result.Stdout, "stderr": outcome.result.Stderr}).Error("FAIL: Command")
+			res.AddError(errors.New("Command failed on server " + outcome.id + " with exit code " + strconv.Itoa(outcome.result.ExitCode)))
+		default:
+			logger.WithFields(log.Fields{"exit": outcome.result.ExitCode, "stdout": outcome.result.Stdout, "stderr": outcome.result.Stderr}).Info("PASS: Command")
+		}
+	}
+	log.WithFields(log.Fields{"servers": len(outcomes), "failed": failed, "skipped": skipped}).Info("EXEC: Finished")
+
+	// the result is already failed if a selected server could not be used
+	if failed > 0 || skipped > 0 {
+		res.MarkFailed()
+	}
+	res.MarkFinished()
+
+	return res.Result()
+}
+
+// Run a command on the targets in parallel, returning the outcomes in target order
+func runExec(command string, targets []execTarget, failFast bool) []execOutcome {
+	outcomes := make([]execOutcome, len(targets))
+	slots := make(chan struct{}, UPCLOUD_EXEC_PARALLEL)
+
+	var lock sync.Mutex
+	stopped := false
+
+	var wait sync.WaitGroup
+	for index, target := range targets {
+		wait.Add(1)
+		go func(index int, target execTarget) {
+			defer wait.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			outcome := execOutcome{execTarget: target}
+
+			lock.Lock()
+			outcome.skipped = stopped
+			lock.Unlock()
+
+			if !outcome.skipped {
+				log.WithFields(log.Fields{"id": target.id, "host": target.target.Host()}).Debug("EXEC: Running command")
+				outcome.result, outcome.err = target.target.Run(command, nil)
+				if outcome.err != nil && failFast {
+					lock.Lock()
+					stopped = true
+					lock.Unlock()
+				}
+			}
+			outcomes[index] = outcome
+		}(index, target)
+	}
+	wait.Wait()
+
+	return outcomes
+}
+
+// Is a server selected by id, either directly or through its replica group
+func execIdSelected(ids []string, id string, group string) bool {
+	for _, selected := range ids {
+		if selected == id || (group != "" && selected == group) {
+			return true
+		}
+	}
+	return false
+}
+
+// Does a list contain a value, ignoring case as UpCloud tags are upper case
+func execHasValue(values []string, value string) bool {
+	for _, each := range values {
+		if strings.EqualFold(each, value) {
+			return true
+		}
+	}
+	return false
+}
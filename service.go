@@ -1,6 +1,17 @@
 package upcloud
 
 import (
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	upcloud "github.com/Jalle19/upcloud-go-sdk/upcloud"
+	upcloud_client "github.com/Jalle19/upcloud-go-sdk/upcloud/client"
+	upcloud_request "github.com/Jalle19/upcloud-go-sdk/upcloud/request"
 	upcloud_service "github.com/Jalle19/upcloud-go-sdk/upcloud/service"
 )
 
@@ -15,11 +26,838 @@ import (
 // Constructor for UpcloudServiceWrapper
 func New_UpcloudServiceWrapper(service upcloud_service.Service) *UpcloudServiceWrapper {
 	return &UpcloudServiceWrapper{
-		Service: service,
+		service:  service,
+		settings: &UpcloudBuilderSettings{},
+		retry:    New_UpcloudRetryPolicy(),
 	}
 }
 
-// Wrapper for the upcloud service, so that we can limit operations
+/**
+ * Wrapper for the upcloud service, so that we can limit operations
+ *
+ * Mutating calls on servers and storages are checked against the
+ * project scope, made from the state, the server definitions and
+ * any Hosts and Storages listed in the builder settings, and refused
+ * with an UpcloudScopeError if the resource is not a part of the
+ * project, unless the wrapper has been marked as global.  Servers
+ * created through any wrapper are considered a part of the project.
+ *
+ * A read only wrapper refuses all mutating calls with an
+ * UpcloudReadOnlyError.
+ *
+ * Any inventory cache given to the wrapper is invalidated
+ * after each mutating call.
+ */
 type UpcloudServiceWrapper struct {
-	upcloud_service.Service
+	// the SDK service is kept private, so that calls can't bypass the wrapper
+	service upcloud_service.Service
+
+	settings *UpcloudBuilderSettings
+	global   bool
+	readOnly bool
+	retry    UpcloudRetryPolicy
+	scope    *UpcloudProjectScope
+
+	inventory *UpcloudInventory
+}
+
+// Servers created through a wrapper in this process, which are always in scope
+var createdServers = struct {
+	sync.Mutex
+	uuids map[string]bool
+}{uuids: map[string]bool{}}
+
+// Set the builder settings used to scope and configure calls
+func (wrapper *UpcloudServiceWrapper) SetBuilderSettings(settings *UpcloudBuilderSettings) {
+	wrapper.settings = settings
+	wrapper.retry = settings.Retry.Policy()
+	if settings.ReadOnly {
+		wrapper.SetReadOnly()
+	}
+}
+
+// Set the project resources, which mutating calls are allowed on
+func (wrapper *UpcloudServiceWrapper) SetProjectScope(scope *UpcloudProjectScope) {
+	wrapper.scope = scope
+}
+
+// Set an inventory cache to invalidate on mutating calls
+func (wrapper *UpcloudServiceWrapper) SetInventory(inventory *UpcloudInventory) {
+	wrapper.inventory = inventory
+}
+
+// Invalidate any inventory cache, after a mutating call
+func (wrapper *UpcloudServiceWrapper) mutated() {
+	if wrapper.inventory != nil {
+		wrapper.inventory.Invalidate()
+	}
+}
+
+// Refuse all mutating calls.  This can't be undone.
+func (wrapper *UpcloudServiceWrapper) SetReadOnly() {
+	wrapper.readOnly = true
+}
+
+// Is this wrapper read only?
+func (wrapper *UpcloudServiceWrapper) ReadOnly() bool {
+	return wrapper.readOnly
+}
+
+// Allow mutating calls on resources outside of the project scope
+func (wrapper *UpcloudServiceWrapper) SetGlobal(global bool) {
+	wrapper.global = global
+}
+
+// Set the policy used to retry failed calls
+func (wrapper *UpcloudServiceWrapper) SetRetryPolicy(retry UpcloudRetryPolicy) {
+	wrapper.retry = retry
+}
+
+// Check that a mutating call is allowed
+func (wrapper *UpcloudServiceWrapper) allowCall(call string) error {
+	if wrapper.readOnly {
+		log.WithFields(log.Fields{"call": call}).Error("UpCloud access is read only. UpCloud call refused.")
+		return &UpcloudReadOnlyError{Call: call}
+	}
+	return nil
+}
+
+// Check that a mutating call is allowed, and that the server is in the project scope
+func (wrapper *UpcloudServiceWrapper) allowServerCall(call, uuid string) error {
+	if err := wrapper.allowCall(call); err != nil {
+		return err
+	}
+	if wrapper.global || wrapper.serverInScope(uuid) {
+		return nil
+	}
+	log.WithFields(log.Fields{"call": call, "uuid": uuid}).Error("Server UUID not a part of the project. UpCloud call refused.")
+	return &UpcloudScopeError{Call: call, Resource: "server", UUID: uuid}
+}
+
+// Check that a mutating call is allowed, and that the storage is in the project scope
+func (wrapper *UpcloudServiceWrapper) allowStorageCall(call, uuid string) error {
+	if err := wrapper.allowCall(call); err != nil {
+		return err
+	}
+	if wrapper.global || wrapper.storageInScope(uuid) {
+		return nil
+	}
+	log.WithFields(log.Fields{"call": call, "uuid": uuid}).Error("Storage UUID not a part of the project. UpCloud call refused.")
+	return &UpcloudScopeError{Call: call, Resource: "storage", UUID: uuid}
+}
+
+/**
+ * Is a server a part of the project?
+ *
+ * A server is in the project if it is listed in the Hosts setting,
+ * was created through a wrapper in this process, is recorded in the
+ * state, is titled for one of the current server definitions, or
+ * has a project tag.
+ */
+func (wrapper *UpcloudServiceWrapper) serverInScope(uuid string) bool {
+	if wrapper.settings.HostsInclude(uuid) {
+		return true
+	}
+
+	createdServers.Lock()
+	created := createdServers.uuids[uuid]
+	createdServers.Unlock()
+	if created {
+		return true
+	}
+
+	if wrapper.scope == nil {
+		return false
+	}
+	if wrapper.scope.servers[uuid] {
+		return true
+	}
+	if len(wrapper.scope.ids) == 0 && len(wrapper.settings.Tags) == 0 {
+		return false
+	}
+	details, err := wrapper.GetServerDetails(&upcloud_request.GetServerDetailsRequest{UUID: uuid})
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{"uuid": uuid}).Error("Could not retrieve server details to check the project scope")
+		return false
+	}
+	return wrapper.scope.Titled(details.Title) || hasProjectTag(details.Tags, wrapper.settings.Tags)
+}
+
+/**
+ * Is a storage a part of the project?
+ *
+ * A storage is in the project if it is listed in the Storages setting,
+ * is recorded in the state, or is attached to a project server.
+ */
+func (wrapper *UpcloudServiceWrapper) storageInScope(uuid string) bool {
+	if wrapper.settings.StoragesInclude(uuid) {
+		return true
+	}
+	if wrapper.scope == nil {
+		return false
+	}
+	if wrapper.scope.storages[uuid] {
+		return true
+	}
+	details, err := wrapper.GetStorageDetails(&upcloud_request.GetStorageDetailsRequest{UUID: uuid})
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{"uuid": uuid}).Error("Could not retrieve storage details to check the project scope")
+		return false
+	}
+	for _, serverUUID := range details.ServerUUIDs {
+		if wrapper.serverInScope(serverUUID) {
+			return true
+		}
+	}
+	return false
+}
+
+// Constructor for UpcloudProjectScope, from the state and the current server definitions
+func New_UpcloudProjectScope(state *UpcloudState, serverDefinitions *ServerDefinitions) *UpcloudProjectScope {
+	scope := UpcloudProjectScope{
+		servers:  map[string]bool{},
+		storages: map[string]bool{},
+		ids:      map[string]bool{},
+	}
+	if state != nil {
+		for _, id := range state.Ids() {
+			recorded, _ := state.Get(id)
+			scope.servers[recorded.UUID] = true
+			for _, storage := range recorded.Storages {
+				scope.storages[storage] = true
+			}
+		}
+		for _, storage := range state.Released() {
+			scope.storages[storage] = true
+		}
+	}
+	if serverDefinitions != nil {
+		for _, id := range serverDefinitions.Order() {
+			scope.ids[id] = true
+		}
+	}
+	return &scope
+}
+
+// The resources which belong to the project, used to scope mutating calls
+type UpcloudProjectScope struct {
+	// server and storage UUIDs recorded in the state
+	servers  map[string]bool
+	storages map[string]bool
+	// ids of the server definitions, which project servers are titled with
+	ids map[string]bool
+}
+
+// Is a server title a "KRAUT:<id>:" title of one of the server definitions?
+func (scope *UpcloudProjectScope) Titled(title string) bool {
+	if !strings.HasPrefix(title, "KRAUT:") {
+		return false
+	}
+	parts := strings.SplitN(title, ":", 3)
+	return len(parts) == 3 && scope.ids[parts[1]]
+}
+
+// Error returned when a call is refused because a resource is outside of the project scope
+type UpcloudScopeError struct {
+	Call     string
+	Resource string
+	UUID     string
+}
+
+// Return the error message
+func (scopeError *UpcloudScopeError) Error() string {
+	return "UpCloud " + scopeError.Call + " refused, " + scopeError.Resource + " " + scopeError.UUID + " is not a part of the project"
+}
+
+// Is an error a scope error?
+func IsUpcloudScopeError(err error) bool {
+	_, ok := err.(*UpcloudScopeError)
+	return ok
+}
+
+// Error returned when a mutating call is refused because access is read only
+type UpcloudReadOnlyError struct {
+	Call string
+}
+
+// Return the error message
+func (readOnlyError *UpcloudReadOnlyError) Error() string {
+	return "UpCloud " + readOnlyError.Call + " refused, UpCloud access is read only"
+}
+
+// Is an error a read only error?
+func IsUpcloudReadOnlyError(err error) bool {
+	_, ok := err.(*UpcloudReadOnlyError)
+	return ok
+}
+
+/**
+ * Mutating calls are checked against read only access, and
+ * the project scope, and are recorded in the API call metrics
+ */
+
+// Create a server, which is then in scope
+func (wrapper *UpcloudServiceWrapper) CreateServer(r *upcloud_request.CreateServerRequest) (*upcloud.ServerDetails, error) {
+	if err := wrapper.allowCall("CreateServer"); err != nil {
+		return nil, err
+	}
+	defer wrapper.mutated()
+	var details *upcloud.ServerDetails
+	err := observeApiCallRun("CreateServer", func() (err error) {
+		details, err = wrapper.service.CreateServer(r)
+		return err
+	})
+	if err == nil {
+		createdServers.Lock()
+		createdServers.uuids[details.UUID] = true
+		createdServers.Unlock()
+	}
+	return details, err
+}
+
+// Start a project server
+func (wrapper *UpcloudServiceWrapper) StartServer(r *upcloud_request.StartServerRequest) (*upcloud.ServerDetails, error) {
+	if err := wrapper.allowServerCall("StartServer", r.UUID); err != nil {
+		return nil, err
+	}
+	defer wrapper.mutated()
+	var details *upcloud.ServerDetails
+	err := observeApiCallRun("StartServer", func() (err error) {
+		details, err = wrapper.service.StartServer(r)
+		return err
+	})
+	return details, err
+}
+
+// Stop a project server
+func (wrapper *UpcloudServiceWrapper) StopServer(r *upcloud_request.StopServerRequest) (*upcloud.ServerDetails, error) {
+	if err := wrapper.allowServerCall("StopServer", r.UUID); err != nil {
+		return nil, err
+	}
+	defer wrapper.mutated()
+	var details *upcloud.ServerDetails
+	err := observeApiCallRun("StopServer", func() (err error) {
+		details, err = wrapper.service.StopServer(r)
+		return err
+	})
+	return details, err
+}
+
+// Restart a project server
+func (wrapper *UpcloudServiceWrapper) RestartServer(r *upcloud_request.RestartServerRequest) (*upcloud.ServerDetails, error) {
+	if err := wrapper.allowServerCall("RestartServer", r.UUID); err != nil {
+		return nil, err
+	}
+	defer wrapper.mutated()
+	var details *upcloud.ServerDetails
+	err := observeApiCallRun("RestartServer", func() (err error) {
+		details, err = wrapper.service.RestartServer(r)
+		return err
+	})
+	return details, err
+}
+
+// Modify a project server
+func (wrapper *UpcloudServiceWrapper) ModifyServer(r *upcloud_request.ModifyServerRequest) (*upcloud.ServerDetails, error) {
+	if err := wrapper.allowServerCall("ModifyServer", r.UUID); err != nil {
+		return nil, err
+	}
+	defer wrapper.mutated()
+	var details *upcloud.ServerDetails
+	err := observeApiCallRun("ModifyServer", func() (err error) {
+		details, err = wrapper.service.ModifyServer(r)
+		return err
+	})
+	return details, err
+}
+
+// Delete a project server
+func (wrapper *UpcloudServiceWrapper) DeleteServer(r *upcloud_request.DeleteServerRequest) error {
+	if err := wrapper.allowServerCall("DeleteServer", r.UUID); err != nil {
+		return err
+	}
+	defer wrapper.mutated()
+	return observeApiCallRun("DeleteServer", func() error {
+		return wrapper.service.DeleteServer(r)
+	})
+}
+
+// Delete a project server and its storages
+func (wrapper *UpcloudServiceWrapper) DeleteServerAndStorages(r *upcloud_request.DeleteServerAndStoragesRequest) error {
+	if err := wrapper.allowServerCall("DeleteServerAndStorages", r.UUID); err != nil {
+		return err
+	}
+	defer wrapper.mutated()
+	return observeApiCallRun("DeleteServerAndStorages", func() error {
+		return wrapper.service.DeleteServerAndStorages(r)
+	})
+}
+
+// Tag a project server
+func (wrapper *UpcloudServiceWrapper) TagServer(r *upcloud_request.TagServerRequest) (*upcloud.ServerDetails, error) {
+	if err := wrapper.allowServerCall("TagServer", r.UUID); err != nil {
+		return nil, err
+	}
+	defer wrapper.mutated()
+	var details *upcloud.ServerDetails
+	err := observeApiCallRun("TagServer", func() (err error) {
+		details, err = wrapper.service.TagServer(r)
+		return err
+	})
+	return details, err
+}
+
+// Untag a project server
+func (wrapper *UpcloudServiceWrapper) UntagServer(r *upcloud_request.UntagServerRequest) (*upcloud.ServerDetails, error) {
+	if err := wrapper.allowServerCall("UntagServer", r.UUID); err != nil {
+		return nil, err
+	}
+	defer wrapper.mutated()
+	var details *upcloud.ServerDetails
+	err := observeApiCallRun("UntagServer", func() (err error) {
+		details, err = wrapper.service.UntagServer(r)
+		return err
+	})
+	return details, err
+}
+
+// Create a firewall rule on a project server
+func (wrapper *UpcloudServiceWrapper) CreateFirewallRule(r *upcloud_request.CreateFirewallRuleRequest) (*upcloud.FirewallRule, error) {
+	if err := wrapper.allowServerCall("CreateFirewallRule", r.ServerUUID); err != nil {
+		return nil, err
+	}
+	defer wrapper.mutated()
+	var rule *upcloud.FirewallRule
+	err := observeApiCallRun("CreateFirewallRule", func() (err error) {
+		rule, err = wrapper.service.CreateFirewallRule(r)
+		return err
+	})
+	return rule, err
+}
+
+// Delete a firewall rule from a project server
+func (wrapper *UpcloudServiceWrapper) DeleteFirewallRule(r *upcloud_request.DeleteFirewallRuleRequest) error {
+	if err := wrapper.allowServerCall("DeleteFirewallRule", r.ServerUUID); err != nil {
+		return err
+	}
+	defer wrapper.mutated()
+	return observeApiCallRun("DeleteFirewallRule", func() error {
+		return wrapper.service.DeleteFirewallRule(r)
+	})
+}
+
+// Modify a project storage
+func (wrapper *UpcloudServiceWrapper) ModifyStorage(r *upcloud_request.ModifyStorageRequest) (*upcloud.StorageDetails, error) {
+	if err := wrapper.allowStorageCall("ModifyStorage", r.UUID); err != nil {
+		return nil, err
+	}
+	defer wrapper.mutated()
+	var details *upcloud.StorageDetails
+	err := observeApiCallRun("ModifyStorage", func() (err error) {
+		details, err = wrapper.service.ModifyStorage(r)
+		return err
+	})
+	return details, err
+}
+
+// Detach a storage from a project server
+func (wrapper *UpcloudServiceWrapper) DetachStorage(r *upcloud_request.DetachStorageRequest) (*upcloud.ServerDetails, error) {
+	if err := wrapper.allowServerCall("DetachStorage", r.ServerUUID); err != nil {
+		return nil, err
+	}
+	defer wrapper.mutated()
+	var details *upcloud.ServerDetails
+	err := observeApiCallRun("DetachStorage", func() (err error) {
+		details, err = wrapper.service.DetachStorage(r)
+		return err
+	})
+	return details, err
+}
+
+// Delete a project storage
+func (wrapper *UpcloudServiceWrapper) DeleteStorage(r *upcloud_request.DeleteStorageRequest) error {
+	if err := wrapper.allowStorageCall("DeleteStorage", r.UUID); err != nil {
+		return err
+	}
+	defer wrapper.mutated()
+	return observeApiCallRun("DeleteStorage", func() error {
+		return wrapper.service.DeleteStorage(r)
+	})
+}
+
+// Create a storage
+func (wrapper *UpcloudServiceWrapper) CreateStorage(r *upcloud_request.CreateStorageRequest) (*upcloud.StorageDetails, error) {
+	if err := wrapper.allowCall("CreateStorage"); err != nil {
+		return nil, err
+	}
+	defer wrapper.mutated()
+	var details *upcloud.StorageDetails
+	err := observeApiCallRun("CreateStorage", func() (err error) {
+		details, err = wrapper.service.CreateStorage(r)
+		return err
+	})
+	return details, err
+}
+
+// Attach a storage to a project server
+func (wrapper *UpcloudServiceWrapper) AttachStorage(r *upcloud_request.AttachStorageRequest) (*upcloud.ServerDetails, error) {
+	if err := wrapper.allowServerCall("AttachStorage", r.ServerUUID); err != nil {
+		return nil, err
+	}
+	defer wrapper.mutated()
+	var details *upcloud.ServerDetails
+	err := observeApiCallRun("AttachStorage", func() (err error) {
+		details, err = wrapper.service.AttachStorage(r)
+		return err
+	})
+	return details, err
+}
+
+// Clone a project storage
+func (wrapper *UpcloudServiceWrapper) CloneStorage(r *upcloud_request.CloneStorageRequest) (*upcloud.StorageDetails, error) {
+	if err := wrapper.allowStorageCall("CloneStorage", r.UUID); err != nil {
+		return nil, err
+	}
+	defer wrapper.mutated()
+	var details *upcloud.StorageDetails
+	err := observeApiCallRun("CloneStorage", func() (err error) {
+		details, err = wrapper.service.CloneStorage(r)
+		return err
+	})
+	return details, err
+}
+
+// Create a template from a project storage
+func (wrapper *UpcloudServiceWrapper) TemplatizeStorage(r *upcloud_request.TemplatizeStorageRequest) (*upcloud.StorageDetails, error) {
+	if err := wrapper.allowStorageCall("TemplatizeStorage", r.UUID); err != nil {
+		return nil, err
+	}
+	defer wrapper.mutated()
+	var details *upcloud.StorageDetails
+	err := observeApiCallRun("TemplatizeStorage", func() (err error) {
+		details, err = wrapper.service.TemplatizeStorage(r)
+		return err
+	})
+	return details, err
+}
+
+// Load a CD-ROM into a project server
+func (wrapper *UpcloudServiceWrapper) LoadCDROM(r *upcloud_request.LoadCDROMRequest) (*upcloud.ServerDetails, error) {
+	if err := wrapper.allowServerCall("LoadCDROM", r.ServerUUID); err != nil {
+		return nil, err
+	}
+	defer wrapper.mutated()
+	var details *upcloud.ServerDetails
+	err := observeApiCallRun("LoadCDROM", func() (err error) {
+		details, err = wrapper.service.LoadCDROM(r)
+		return err
+	})
+	return details, err
+}
+
+// Eject a CD-ROM from a project server
+func (wrapper *UpcloudServiceWrapper) EjectCDROM(r *upcloud_request.EjectCDROMRequest) (*upcloud.ServerDetails, error) {
+	if err := wrapper.allowServerCall("EjectCDROM", r.ServerUUID); err != nil {
+		return nil, err
+	}
+	defer wrapper.mutated()
+	var details *upcloud.ServerDetails
+	err := observeApiCallRun("EjectCDROM", func() (err error) {
+		details, err = wrapper.service.EjectCDROM(r)
+		return err
+	})
+	return details, err
+}
+
+// Create a backup of a project storage
+func (wrapper *UpcloudServiceWrapper) CreateBackup(r *upcloud_request.CreateBackupRequest) (*upcloud.StorageDetails, error) {
+	if err := wrapper.allowStorageCall("CreateBackup", r.UUID); err != nil {
+		return nil, err
+	}
+	defer wrapper.mutated()
+	var details *upcloud.StorageDetails
+	err := observeApiCallRun("CreateBackup", func() (err error) {
+		details, err = wrapper.service.CreateBackup(r)
+		return err
+	})
+	return details, err
+}
+
+// Restore a backup of a project storage
+func (wrapper *UpcloudServiceWrapper) RestoreBackup(r *upcloud_request.RestoreBackupRequest) error {
+	if err := wrapper.allowStorageCall("RestoreBackup", r.UUID); err != nil {
+		return err
+	}
+	defer wrapper.mutated()
+	return observeApiCallRun("RestoreBackup", func() error {
+		return wrapper.service.RestoreBackup(r)
+	})
+}
+
+// Assign an IP address to a project server
+func (wrapper *UpcloudServiceWrapper) AssignIPAddress(r *upcloud_request.AssignIPAddressRequest) (*upcloud.IPAddress, error) {
+	if err := wrapper.allowServerCall("AssignIPAddress", r.ServerUUID); err != nil {
+		return nil, err
+	}
+	defer wrapper.mutated()
+	var address *upcloud.IPAddress
+	err := observeApiCallRun("AssignIPAddress", func() (err error) {
+		address, err = wrapper.service.AssignIPAddress(r)
+		return err
+	})
+	return address, err
+}
+
+// Modify an IP address
+func (wrapper *UpcloudServiceWrapper) ModifyIPAddress(r *upcloud_request.ModifyIPAddressRequest) (*upcloud.IPAddress, error) {
+	if err := wrapper.allowCall("ModifyIPAddress"); err != nil {
+		return nil, err
+	}
+	defer wrapper.mutated()
+	var address *upcloud.IPAddress
+	err := observeApiCallRun("ModifyIPAddress", func() (err error) {
+		address, err = wrapper.service.ModifyIPAddress(r)
+		return err
+	})
+	return address, err
+}
+
+// Release an IP address
+func (wrapper *UpcloudServiceWrapper) ReleaseIPAddress(r *upcloud_request.ReleaseIPAddressRequest) error {
+	if err := wrapper.allowCall("ReleaseIPAddress"); err != nil {
+		return err
+	}
+	defer wrapper.mutated()
+	return observeApiCallRun("ReleaseIPAddress", func() error {
+		return wrapper.service.ReleaseIPAddress(r)
+	})
+}
+
+// Create a tag
+func (wrapper *UpcloudServiceWrapper) CreateTag(r *upcloud_request.CreateTagRequest) (*upcloud.Tag, error) {
+	if err := wrapper.allowCall("CreateTag"); err != nil {
+		return nil, err
+	}
+	defer wrapper.mutated()
+	var tag *upcloud.Tag
+	err := observeApiCallRun("CreateTag", func() (err error) {
+		tag, err = wrapper.service.CreateTag(r)
+		return err
+	})
+	return tag, err
+}
+
+// Modify a tag
+func (wrapper *UpcloudServiceWrapper) ModifyTag(r *upcloud_request.ModifyTagRequest) (*upcloud.Tag, error) {
+	if err := wrapper.allowCall("ModifyTag"); err != nil {
+		return nil, err
+	}
+	defer wrapper.mutated()
+	var tag *upcloud.Tag
+	err := observeApiCallRun("ModifyTag", func() (err error) {
+		tag, err = wrapper.service.ModifyTag(r)
+		return err
+	})
+	return tag, err
+}
+
+// Delete a tag
+func (wrapper *UpcloudServiceWrapper) DeleteTag(r *upcloud_request.DeleteTagRequest) error {
+	if err := wrapper.allowCall("DeleteTag"); err != nil {
+		return err
+	}
+	defer wrapper.mutated()
+	return observeApiCallRun("DeleteTag", func() error {
+		return wrapper.service.DeleteTag(r)
+	})
+}
+
+// Wait for a server state, after which any cached server state is stale
+func (wrapper *UpcloudServiceWrapper) WaitForServerState(r *upcloud_request.WaitForServerStateRequest) (*upcloud.ServerDetails, error) {
+	defer wrapper.mutated()
+	var details *upcloud.ServerDetails
+	err := observeApiCallRun("WaitForServerState", func() (err error) {
+		details, err = wrapper.service.WaitForServerState(r)
+		return err
+	})
+	return details, err
+}
+
+/**
+ * Read calls are idempotent, so they are retried on transient failures
+ */
+
+// Retrieve account information
+func (wrapper *UpcloudServiceWrapper) GetAccount() (account *upcloud.Account, err error) {
+	err = wrapper.retry.Do("GetAccount", func() error {
+		account, err = wrapper.service.GetAccount()
+		return err
+	})
+	return account, err
+}
+
+// Retrieve the list of zones
+func (wrapper *UpcloudServiceWrapper) GetZones() (zones *upcloud.Zones, err error) {
+	err = wrapper.retry.Do("GetZones", func() error {
+		zones, err = wrapper.service.GetZones()
+		return err
+	})
+	return zones, err
+}
+
+// Retrieve the price list
+func (wrapper *UpcloudServiceWrapper) GetPriceZones() (priceZones *upcloud.PriceZones, err error) {
+	err = wrapper.retry.Do("GetPriceZones", func() error {
+		priceZones, err = wrapper.service.GetPriceZones()
+		return err
+	})
+	return priceZones, err
+}
+
+// Retrieve the list of plans
+func (wrapper *UpcloudServiceWrapper) GetPlans() (plans *upcloud.Plans, err error) {
+	err = wrapper.retry.Do("GetPlans", func() error {
+		plans, err = wrapper.service.GetPlans()
+		return err
+	})
+	return plans, err
+}
+
+// Retrieve the list of servers
+func (wrapper *UpcloudServiceWrapper) GetServers() (servers *upcloud.Servers, err error) {
+	err = wrapper.retry.Do("GetServers", func() error {
+		servers, err = wrapper.service.GetServers()
+		return err
+	})
+	return servers, err
+}
+
+// Retrieve server details
+func (wrapper *UpcloudServiceWrapper) GetServerDetails(r *upcloud_request.GetServerDetailsRequest) (details *upcloud.ServerDetails, err error) {
+	err = wrapper.retry.Do("GetServerDetails", func() error {
+		details, err = wrapper.service.GetServerDetails(r)
+		return err
+	})
+	return details, err
+}
+
+// Retrieve the list of storages
+func (wrapper *UpcloudServiceWrapper) GetStorages(r *upcloud_request.GetStoragesRequest) (storages *upcloud.Storages, err error) {
+	err = wrapper.retry.Do("GetStorages", func() error {
+		storages, err = wrapper.service.GetStorages(r)
+		return err
+	})
+	return storages, err
+}
+
+// Retrieve storage details
+func (wrapper *UpcloudServiceWrapper) GetStorageDetails(r *upcloud_request.GetStorageDetailsRequest) (details *upcloud.StorageDetails, err error) {
+	err = wrapper.retry.Do("GetStorageDetails", func() error {
+		details, err = wrapper.service.GetStorageDetails(r)
+		return err
+	})
+	return details, err
+}
+
+// Retrieve the firewall rules for a server
+func (wrapper *UpcloudServiceWrapper) GetFirewallRules(r *upcloud_request.GetFirewallRulesRequest) (rules *upcloud.FirewallRules, err error) {
+	err = wrapper.retry.Do("GetFirewallRules", func() error {
+		rules, err = wrapper.service.GetFirewallRules(r)
+		return err
+	})
+	return rules, err
+}
+
+// Retrieve the list of IP addresses
+func (wrapper *UpcloudServiceWrapper) GetIPAddresses() (addresses *upcloud.IPAddresses, err error) {
+	err = wrapper.retry.Do("GetIPAddresses", func() error {
+		addresses, err = wrapper.service.GetIPAddresses()
+		return err
+	})
+	return addresses, err
+}
+
+/**
+ * Retry policy
+ */
+
+// Constructor for UpcloudRetryPolicy, with default values
+func New_UpcloudRetryPolicy() UpcloudRetryPolicy {
+	return UpcloudRetryPolicy{
+		Attempts: 3,
+		Delay:    time.Second,
+		MaxDelay: time.Second * 30,
+	}
+}
+
+// How to retry failed calls: exponential backoff with jitter
+type UpcloudRetryPolicy struct {
+	Attempts int
+	Delay    time.Duration
+	MaxDelay time.Duration
+}
+
+// Run a call, retrying it if it fails in a way that may be transient, and recording each attempt in the API call metrics
+func (retry UpcloudRetryPolicy) Do(call string, f func() error) error {
+	delay := retry.Delay
+	for attempt := 1; ; attempt++ {
+		err := observeApiCallRun(call, f)
+		if err == nil || attempt >= retry.Attempts || !isTransientUpcloudError(err) {
+			return err
+		}
+
+		// full jitter, so that parallel callers don't retry in step
+		wait := time.Duration(0)
+		if delay > 0 {
+			wait = time.Duration(rand.Int63n(int64(delay) + 1))
+		}
+		log.WithError(err).WithFields(log.Fields{"call": call, "attempt": attempt, "wait": wait}).Warn("UpCloud call failed, retrying")
+		time.Sleep(wait)
+
+		delay = delay * 2
+		if delay > retry.MaxDelay {
+			delay = retry.MaxDelay
+		}
+	}
+}
+
+/**
+ * Service error codes for failures on the UpCloud side
+ *
+ * The service converts error responses with a JSON body into an
+ * upcloud.Error, which drops the HTTP status, so server side
+ * failures are recognised by their error code instead.
+ */
+var upcloudTransientErrorCodes = []string{
+	"TOO_MANY_REQUESTS",
+	"RATE_LIMIT",
+	"INTERNAL_ERROR",
+	"INTERNAL_SERVER_ERROR",
+	"SERVER_ERROR",
+	"BAD_GATEWAY",
+	"SERVICE_UNAVAILABLE",
+	"GATEWAY_TIMEOUT",
+	"TEMPORARILY_UNAVAILABLE",
+}
+
+// Is an error from the UpCloud service something that may go away if we try again?
+func isTransientUpcloudError(err error) bool {
+	switch typed := err.(type) {
+	case *upcloud_client.Error:
+		// rate limited, or a server side failure
+		return typed.ErrorCode == 429 || typed.ErrorCode >= 500
+	case *upcloud.Error:
+		// service errors with a code are API responses, and will not change unless we are rate limited or the API failed
+		code := strings.ToUpper(typed.ErrorCode)
+		for _, transient := range upcloudTransientErrorCodes {
+			if strings.Contains(code, transient) {
+				return true
+			}
+		}
+		return false
+	case net.Error:
+		return typed.Timeout()
+	}
+	// The service can't parse error responses that don't come from the API, such as gateway errors
+	return strings.Contains(err.Error(), "malformed service error")
 }
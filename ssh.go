@@ -0,0 +1,241 @@
+package upcloud
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	upcloud "github.com/Jalle19/upcloud-go-sdk/upcloud"
+)
+
+const (
+	// Default ssh connect timeout
+	UPCLOUD_SSH_CONNECT_TIMEOUT = 10 * time.Second
+	// Known hosts file for project servers, relative to the project, so that server
+	// host keys are kept out of the user's known_hosts
+	UPCLOUD_SSH_KNOWN_HOSTS_FILE = ".radi/upcloud.known_hosts"
+)
+
+/**
+ * Running commands on project servers over SSH
+ *
+ * Commands are run using the system ssh client, in batch mode,
+ * so that the user's ssh configuration and agent are respected.
+ * Host keys are kept in a known hosts file of the project.
+ */
+
+// Runs commands on a server, an sshTarget except in tests
+type sshRunner interface {
+	Host() string
+	Run(command string, stdin io.Reader) (sshResult, error)
+}
+
+// An ssh connection to a server
+type sshTarget struct {
+	host       string
+	user       string
+	port       int
+	keyFile    string
+	knownHosts string
+	options    []string
+	timeout    time.Duration
+}
+
+// Build an ssh target for a server, from the ssh settings
+func newSSHTarget(settings UpcloudBuilderSettings_SSH, host string, user string) sshTarget {
+	target := sshTarget{
+		host:       host,
+		user:       user,
+		port:       settings.Port,
+		keyFile:    settings.KeyFile,
+		knownHosts: projectPath(UPCLOUD_SSH_KNOWN_HOSTS_FILE),
+		options:    settings.Options,
+		timeout:    UPCLOUD_SSH_CONNECT_TIMEOUT,
+	}
+	if target.user == "" {
+		target.user = settings.User
+	}
+	if target.user == "" {
+		target.user = "root"
+	}
+	if target.port == 0 {
+		target.port = 22
+	}
+	if timeout, err := time.ParseDuration(settings.ConnectTimeout); err == nil {
+		target.timeout = timeout
+	}
+	return target
+}
+
+// Build an ssh target for a provisioned server, using its public IPv4 address
+func newServerSSHTarget(settings UpcloudBuilderSettings_SSH, details *upcloud.ServerDetails, user string) (sshTarget, error) {
+	host := serverAddress(details, upcloud.IPAddressAccessPublic, upcloud.IPAddressFamilyIPv4)
+	if host == "" {
+		return sshTarget{}, errors.New("Server has no public IPv4 address for ssh : " + details.UUID)
+	}
+	return newSSHTarget(settings, host, user), nil
+}
+
+// The ssh command line arguments, up to the remote command
+func (target sshTarget) args() []string {
+	args := []string{
+		"-p", strconv.Itoa(target.port),
+		"-o", "BatchMode=yes",
+		"-o", "ConnectTimeout=" + strconv.Itoa(int(target.timeout.Seconds())),
+		"-o", "StrictHostKeyChecking=accept-new",
+		"-o", "UserKnownHostsFile=" + target.knownHosts,
+	}
+	if target.keyFile != "" {
+		args = append(args, "-i", projectPath(target.keyFile))
+	}
+	for _, option := range target.options {
+		args = append(args, "-o", option)
+	}
+	return append(args, target.user+"@"+target.host)
+}
+
+// The server host name or address
+func (target sshTarget) Host() string {
+	return target.host
+}
+
+/**
+ * Forget the host key of the server
+ *
+ * A new server may have the address of a removed one, so its
+ * old key is forgotten before the first connection to it.
+ */
+func (target sshTarget) ForgetHostKey() error {
+	if _, err := os.Stat(target.knownHosts); os.IsNotExist(err) {
+		return nil
+	}
+	host := target.host
+	if target.port != 22 {
+		host = "[" + target.host + "]:" + strconv.Itoa(target.port)
+	}
+	output, err := exec.Command("ssh-keygen", "-R", host, "-f", target.knownHosts).CombinedOutput()
+	if err != nil {
+		return errors.New("Could not forget the host key of " + host + " : " + strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// The output of a command run over ssh
+type sshResult struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+}
+
+// Run a command on the server, with an optional stdin, which is an error if it doesn't exit with 0
+func (target sshTarget) Run(command string, stdin io.Reader) (sshResult, error) {
+	if err := os.MkdirAll(filepath.Dir(target.knownHosts), 0755); err != nil {
+		return sshResult{ExitCode: -1}, err
+	}
+
+	cmd := exec.Command("ssh", append(target.args(), command)...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if stdin != nil {
+		cmd.Stdin = stdin
+	}
+
+	err := cmd.Run()
+	result := sshResult{
+		Stdout: stdout.String(),
+		Stderr: stderr.String(),
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		result.ExitCode = exitCode(exitErr)
+		return result, errors.New("Command exited with " + strconv.Itoa(result.ExitCode) + " on " + target.host + " : " + strings.TrimSpace(result.Stderr))
+	} else if err != nil {
+		result.ExitCode = -1
+		return result, err
+	}
+	return result, nil
+}
+
+// The exit code of a finished command
+func exitCode(exitErr *exec.ExitError) int {
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+		return status.ExitStatus()
+	}
+	return 1
+}
+
+// A command, or local script file, to run on a server
+type ServerHook struct {
+	Command string
+	Script  string
+}
+
+// A short readable name for the hook
+func (hook ServerHook) Name() string {
+	if hook.Script != "" {
+		return "script " + hook.Script
+	}
+	return "command " + hook.Command
+}
+
+// Check that the hook can be run
+func (hook ServerHook) Validate() error {
+	if (hook.Command == "") == (hook.Script == "") {
+		return errors.New("Server hook needs either a Command or a Script")
+	}
+	if hook.Script != "" {
+		if _, err := os.Stat(projectPath(hook.Script)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run the hook on a server, a script is passed to a remote shell
+func (hook ServerHook) Run(runner sshRunner) (sshResult, error) {
+	if hook.Script != "" {
+		script, err := os.Open(projectPath(hook.Script))
+		if err != nil {
+			return sshResult{ExitCode: -1}, err
+		}
+		defer script.Close()
+		return runner.Run("sh -s", script)
+	}
+	return runner.Run(hook.Command, nil)
+}
+
+// Run the post create hooks for a server in order, stopping at the first failure, returning the output of each hook that ran
+func runPostCreateHooks(id string, runner sshRunner, hooks []ServerHook) ([]UpcloudCommandOutput, []error) {
+	outputs := []UpcloudCommandOutput{}
+	for _, hook := range hooks {
+		logger := log.WithFields(log.Fields{"id": id, "host": runner.Host(), "hook": hook.Name()})
+		result, err := hook.Run(runner)
+		outputs = append(outputs, UpcloudCommandOutput{
+			Id:       id,
+			Host:     runner.Host(),
+			Command:  hook.Name(),
+			ExitCode: result.ExitCode,
+			Stdout:   result.Stdout,
+			Stderr:   result.Stderr,
+			Err:      err,
+		})
+		if err != nil {
+			logger.WithError(err).WithFields(log.Fields{"exit": result.ExitCode, "stdout": result.Stdout, "stderr": result.Stderr}).Error("FAIL: Post create hook")
+			return outputs, []error{
+				err,
+				errors.New("Post create hook " + hook.Name() + " failed for server " + id + "\nstdout:\n" + result.Stdout + "\nstderr:\n" + result.Stderr),
+			}
+		}
+		logger.WithFields(log.Fields{"stdout": result.Stdout, "stderr": result.Stderr}).Info("PASS: Post create hook")
+	}
+	return outputs, []error{}
+}
@@ -0,0 +1,327 @@
+package upcloud
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A stand in for an ssh connection, which answers commands from a map
+type fakeSSHRunner struct {
+	results map[string]sshResult
+	// commands that fail, exiting with the result exit code
+	failing map[string]bool
+
+	commands []string
+	stdins   []string
+}
+
+func (runner *fakeSSHRunner) Host() string {
+	return "192.0.2.10"
+}
+
+func (runner *fakeSSHRunner) Run(command string, stdin io.Reader) (sshResult, error) {
+	runner.commands = append(runner.commands, command)
+	input := ""
+	if stdin != nil {
+		source, _ := ioutil.ReadAll(stdin)
+		input = string(source)
+	}
+	runner.stdins = append(runner.stdins, input)
+
+	result := runner.results[command]
+	if runner.failing[command] {
+		return result, errors.New("Command failed : " + command)
+	}
+	return result, nil
+}
+
+func TestRunPostCreateHooksOutputs(t *testing.T) {
+	runner := &fakeSSHRunner{
+		results: map[string]sshResult{
+			"hostname": {Stdout: "web-1\n"},
+			"uptime":   {Stdout: "up 1 min\n", Stderr: "warning\n"},
+		},
+	}
+	hooks := []ServerHook{{Command: "hostname"}, {Command: "uptime"}}
+
+	outputs, errs := runPostCreateHooks("web-1", runner, hooks)
+	if len(errs) > 0 {
+		t.Fatalf("hooks failed: %v", errs)
+	}
+	if len(outputs) != 2 {
+		t.Fatalf("got %d hook outputs, want one for each of the 2 hooks", len(outputs))
+	}
+	if outputs[0].Id != "web-1" || outputs[0].Host != "192.0.2.10" || outputs[0].Command != "command hostname" || outputs[0].Stdout != "web-1\n" {
+		t.Errorf("unexpected output for the first hook: %+v", outputs[0])
+	}
+	if outputs[1].Stdout != "up 1 min\n" || outputs[1].Stderr != "warning\n" || outputs[1].Err != nil {
+		t.Errorf("unexpected output for the second hook: %+v", outputs[1])
+	}
+}
+
+func TestRunPostCreateHooksStopsAtFailure(t *testing.T) {
+	runner := &fakeSSHRunner{
+		results: map[string]sshResult{
+			"false": {ExitCode: 1, Stderr: "no\n"},
+		},
+		failing: map[string]bool{"false": true},
+	}
+	hooks := []ServerHook{{Command: "true"}, {Command: "false"}, {Command: "never"}}
+
+	outputs, errs := runPostCreateHooks("web-1", runner, hooks)
+	if len(errs) == 0 {
+		t.Fatal("a failing hook was not reported")
+	}
+	if len(runner.commands) != 2 {
+		t.Errorf("ran %v, hooks after a failure should not run", runner.commands)
+	}
+	if len(outputs) != 2 {
+		t.Fatalf("got %d hook outputs, want one for each hook that ran", len(outputs))
+	}
+	if failed := outputs[1]; failed.ExitCode != 1 || failed.Stderr != "no\n" || failed.Err == nil {
+		t.Errorf("the failed hook output should keep its exit code, stderr and error: %+v", failed)
+	}
+	if !strings.Contains(errs[len(errs)-1].Error(), "no\n") {
+		t.Errorf("the failure should include the hook stderr: %s", errs[len(errs)-1])
+	}
+}
+
+func TestServerHookScript(t *testing.T) {
+	dir, err := ioutil.TempDir("", "upcloud-hook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	script := filepath.Join(dir, "setup.sh")
+	if err := ioutil.WriteFile(script, []byte("echo setup\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	hook := ServerHook{Script: script}
+	if err := hook.Validate(); err != nil {
+		t.Fatalf("hook with an existing script is invalid: %s", err)
+	}
+
+	runner := &fakeSSHRunner{results: map[string]sshResult{"sh -s": {Stdout: "setup\n"}}}
+	result, err := hook.Run(runner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runner.commands[0] != "sh -s" || runner.stdins[0] != "echo setup\n" {
+		t.Errorf("script should be passed to a remote shell, ran %q with %q", runner.commands[0], runner.stdins[0])
+	}
+	if result.Stdout != "setup\n" {
+		t.Errorf("got stdout %q, want the script output", result.Stdout)
+	}
+
+	if err := (ServerHook{Script: filepath.Join(dir, "missing.sh")}).Validate(); err == nil {
+		t.Error("hook with a missing script is valid")
+	}
+	if err := (ServerHook{}).Validate(); err == nil {
+		t.Error("hook without a command or script is valid")
+	}
+}
+
+func TestSSHTargetKnownHosts(t *testing.T) {
+	target := newSSHTarget(UpcloudBuilderSettings_SSH{}, "192.0.2.10", "")
+
+	knownHosts := ""
+	args := target.args()
+	for index, arg := range args {
+		if index > 0 && args[index-1] == "-o" && strings.HasPrefix(arg, "UserKnownHostsFile=") {
+			knownHosts = strings.TrimPrefix(arg, "UserKnownHostsFile=")
+		}
+	}
+	if knownHosts == "" {
+		t.Fatalf("ssh should use the project known hosts file: %v", args)
+	}
+	if !filepath.IsAbs(knownHosts) || !strings.HasSuffix(knownHosts, filepath.FromSlash(UPCLOUD_SSH_KNOWN_HOSTS_FILE)) {
+		t.Errorf("known hosts file %q should be in the project", knownHosts)
+	}
+	if last := args[len(args)-1]; last != "root@192.0.2.10" {
+		t.Errorf("ssh destination is %q, want root@192.0.2.10", last)
+	}
+}
+
+// Start an sshd on a local port, which accepts a generated key for the current user
+func sshTestServer(t *testing.T) (UpcloudBuilderSettings_SSH, string) {
+	if _, err := exec.LookPath("ssh"); err != nil {
+		t.Skip("ssh is not available")
+	}
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen is not available")
+	}
+	sshd, err := exec.LookPath("sshd")
+	if err != nil {
+		if _, err := os.Stat("/usr/sbin/sshd"); err != nil {
+			t.Skip("sshd is not available")
+		}
+		sshd = "/usr/sbin/sshd"
+	}
+	current, err := user.Current()
+	if err != nil {
+		t.Skip("the current user is not known")
+	}
+
+	dir, err := ioutil.TempDir("", "upcloud-sshd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	for _, key := range []string{"host_key", "id_ed25519"} {
+		if output, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-f", filepath.Join(dir, key)).CombinedOutput(); err != nil {
+			t.Fatalf("could not generate %s: %s", key, output)
+		}
+	}
+	public, err := ioutil.ReadFile(filepath.Join(dir, "id_ed25519.pub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "authorized_keys"), public, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	port := probeTestClosedPort(t)
+	config := strings.Join([]string{
+		"Port " + strconv.Itoa(port),
+		"ListenAddress 127.0.0.1",
+		"HostKey " + filepath.Join(dir, "host_key"),
+		"AuthorizedKeysFile " + filepath.Join(dir, "authorized_keys"),
+		"PidFile " + filepath.Join(dir, "sshd.pid"),
+		"PasswordAuthentication no",
+		"PubkeyAuthentication yes",
+		"StrictModes no",
+	}, "\n") + "\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "sshd_config"), []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(sshd, "-D", "-e", "-f", filepath.Join(dir, "sshd_config"))
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		t.Skipf("could not start sshd: %s", err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		<-exited
+	})
+
+	// wait for sshd to listen, it may refuse to run here, for example without a privilege separation directory
+	for start := time.Now(); ; {
+		select {
+		case <-exited:
+			t.Skipf("sshd could not be run: %s", stderr.String())
+		default:
+		}
+		if conn, err := net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(port)); err == nil {
+			conn.Close()
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Skip("sshd did not start listening")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	settings := UpcloudBuilderSettings_SSH{
+		User:           current.Username,
+		Port:           port,
+		KeyFile:        filepath.Join(dir, "id_ed25519"),
+		ConnectTimeout: "5s",
+	}
+	return settings, dir
+}
+
+// Is there a known host key for the target?
+func sshTestKnownHost(target sshTarget) bool {
+	host := "[" + target.host + "]:" + strconv.Itoa(target.port)
+	return exec.Command("ssh-keygen", "-F", host, "-f", target.knownHosts).Run() == nil
+}
+
+func TestSSHTargetLocalServer(t *testing.T) {
+	settings, dir := sshTestServer(t)
+
+	target := newSSHTarget(settings, "127.0.0.1", "")
+	// keep the test host key out of the project known hosts file
+	target.knownHosts = filepath.Join(dir, "state", "known_hosts")
+
+	result, err := target.Run("echo hello", nil)
+	if err != nil {
+		t.Fatalf("ssh to the local server failed: %s (stderr %q)", err, result.Stderr)
+	}
+	if result.Stdout != "hello\n" || result.ExitCode != 0 {
+		t.Errorf("unexpected result from the local server: %+v", result)
+	}
+	if !sshTestKnownHost(target) {
+		t.Fatal("the host key should be added to the known hosts file on first connection")
+	}
+
+	script := filepath.Join(dir, "hook.sh")
+	if err := ioutil.WriteFile(script, []byte("echo from script\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if result, err := (ServerHook{Script: script}).Run(target); err != nil || result.Stdout != "from script\n" {
+		t.Errorf("script hook got %+v, %v", result, err)
+	}
+
+	result, err = target.Run("echo failing >&2; exit 3", nil)
+	if err == nil || result.ExitCode != 3 || result.Stderr != "failing\n" {
+		t.Errorf("a failing command should keep its exit code and stderr, got %+v, %v", result, err)
+	}
+
+	if err := target.ForgetHostKey(); err != nil {
+		t.Fatal(err)
+	}
+	if sshTestKnownHost(target) {
+		t.Error("the host key should be forgotten")
+	}
+	if _, err := target.Run("true", nil); err != nil {
+		t.Errorf("ssh after forgetting the host key failed: %s", err)
+	}
+}
+
+func TestSSHTargetChangedHostKey(t *testing.T) {
+	settings, dir := sshTestServer(t)
+
+	target := newSSHTarget(settings, "127.0.0.1", "")
+	target.knownHosts = filepath.Join(dir, "known_hosts")
+
+	// a different key recorded for the address, as if the server was replaced
+	if output, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-f", filepath.Join(dir, "old_key")).CombinedOutput(); err != nil {
+		t.Fatalf("could not generate a key: %s", output)
+	}
+	old, err := ioutil.ReadFile(filepath.Join(dir, "old_key.pub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := strings.Fields(string(old))
+	entry := "[127.0.0.1]:" + strconv.Itoa(settings.Port) + " " + fields[0] + " " + fields[1] + "\n"
+	if err := ioutil.WriteFile(target.knownHosts, []byte(entry), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := target.Run("true", nil); err == nil {
+		t.Fatal("ssh should refuse a server whose host key has changed")
+	}
+	if err := target.ForgetHostKey(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := target.Run("true", nil); err != nil {
+		t.Errorf("ssh after forgetting the old host key failed: %s", err)
+	}
+}
@@ -0,0 +1,264 @@
+package upcloud
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+
+	upcloud "github.com/Jalle19/upcloud-go-sdk/upcloud"
+
+	api_operation "github.com/wunderkraut/radi-api/operation"
+	api_property "github.com/wunderkraut/radi-api/property"
+	api_result "github.com/wunderkraut/radi-api/result"
+	api_usage "github.com/wunderkraut/radi-api/usage"
+)
+
+const (
+	// Markers around the managed block in an ssh config file
+	UPCLOUD_SSHCONFIG_BEGIN = "# BEGIN radi upcloud"
+	UPCLOUD_SSHCONFIG_END   = "# END radi upcloud"
+)
+
+/**
+ * ssh config Host entries for the provisioned project servers
+ *
+ * The entries are written to stdout, or into a managed block of an
+ * ssh config file.  The block is marked with the project root, so
+ * that several projects can share one file, and anything outside
+ * of the block is left as it is.  Host aliases are prefixed with
+ * the project name, as in "myproject-web-1", so that the servers
+ * of different projects don't collide.
+ */
+
+// ssh config generation operation
+type UpcloudMonitorSSHConfigOperation struct {
+	BaseUpcloudServiceOperation
+}
+
+// Return the string machinename/id of the Operation
+func (sshConfig *UpcloudMonitorSSHConfigOperation) Id() string {
+	return "upcloud.monitor.ssh.config"
+}
+
+// Return a user readable string label for the Operation
+func (sshConfig *UpcloudMonitorSSHConfigOperation) Label() string {
+	return "ssh config"
+}
+
+// return a multiline string description for the Operation
+func (sshConfig *UpcloudMonitorSSHConfigOperation) Description() string {
+	return "Generate ssh config Host entries for the provisioned project servers."
+}
+
+// return a multiline string man page for the Operation
+func (sshConfig *UpcloudMonitorSSHConfigOperation) Help() string {
+	return `Give an output file, such as ~/.ssh/config, to update the project block
+in that file, instead of writing the entries to stdout.  Hosts are named
+<project>-<server id>, where the project is the project folder name.`
+}
+
+// Is this operation meant to be used only inside the API
+func (sshConfig *UpcloudMonitorSSHConfigOperation) Usage() api_usage.Usage {
+	return api_operation.Usage_External()
+}
+
+// Run a validation check on the Operation
+func (sshConfig *UpcloudMonitorSSHConfigOperation) Validate() api_result.Result {
+	return api_result.MakeSuccessfulResult()
+}
+
+// What settings/values does the Operation provide to an implemenentor
+func (sshConfig *UpcloudMonitorSSHConfigOperation) Properties() api_property.Properties {
+	props := api_property.New_SimplePropertiesEmpty()
+
+	props.Add(api_property.Property(&UpcloudOutputProperty{}))
+
+	return props.Properties()
+}
+
+// Execute the Operation
+func (sshConfig *UpcloudMonitorSSHConfigOperation) Exec(props api_property.Properties) api_result.Result {
+	res := api_result.New_StandardResult()
+
+	settings := sshConfig.BuilderSettings()
+	serverDefinitions := sshConfig.ServerDefinitions()
+
+	output := ""
+	if outputProp, found := props.Get(UPCLOUD_OUTPUT_PROPERTY); found {
+		output = outputProp.Get().(string)
+	}
+
+	root := projectRoot()
+	project := sshConfigProjectName(root)
+
+	failed := false
+	var entries bytes.Buffer
+	for _, id := range serverDefinitions.Order() {
+		serverDefinition, _ := serverDefinitions.Get(id)
+		if !serverDefinition.IsCreated() {
+			continue
+		}
+		details, err := serverDefinition.GetServerDetails()
+		if err != nil {
+			res.AddError(err)
+			res.AddError(errors.New("Could not retrieve server details : " + id))
+			failed = true
+			continue
+		}
+		host := serverAddress(details, upcloud.IPAddressAccessPublic, upcloud.IPAddressFamilyIPv4)
+		if host == "" {
+			log.WithFields(log.Fields{"id": id, "UUID": details.UUID}).Warn("Server has no public IPv4 address, so it has no ssh config entry")
+			continue
+		}
+
+		user := settings.SSH.User
+		if request := serverDefinition.CreateServerRequest(); request.LoginUser != nil && request.LoginUser.Username != "" {
+			user = request.LoginUser.Username
+		}
+		identity := serverDefinition.IdentityFile()
+		if identity == "" && settings.SSH.KeyFile != "" {
+			identity = projectPath(settings.SSH.KeyFile)
+		}
+
+		entries.WriteString("Host " + project + "-" + id + "\n")
+		entries.WriteString("    HostName " + host + "\n")
+		if user != "" {
+			entries.WriteString("    User " + user + "\n")
+		}
+		if settings.SSH.Port > 0 {
+			entries.WriteString("    Port " + strconv.Itoa(settings.SSH.Port) + "\n")
+		}
+		if identity != "" {
+			entries.WriteString("    IdentityFile " + identity + "\n")
+			entries.WriteString("    IdentitiesOnly yes\n")
+		}
+		entries.WriteString("    UserKnownHostsFile " + projectPath(UPCLOUD_SSH_KNOWN_HOSTS_FILE) + "\n")
+		entries.WriteString("\n")
+	}
+
+	var err error
+	if output == "" {
+		err = writeOutput("", entries.Bytes())
+	} else {
+		err = updateSSHConfigBlock(expandHomePath(output), root, entries.String())
+		if err == nil {
+			log.WithFields(log.Fields{"path": output}).Info("Updated ssh config")
+		}
+	}
+	if err != nil {
+		res.AddError(err)
+		res.AddError(errors.New("Could not write the ssh config."))
+		failed = true
+	}
+
+	if failed {
+		res.MarkFailed()
+	} else {
+		res.MarkSuccess()
+	}
+	res.MarkFinished()
+
+	return res.Result()
+}
+
+// Characters which are not used in host alias prefixes
+var sshConfigProjectNameInvalid = regexp.MustCompile("[^a-zA-Z0-9_.-]+")
+
+// The project name used to prefix host aliases, from the project root folder
+func sshConfigProjectName(root string) string {
+	name := strings.Trim(sshConfigProjectNameInvalid.ReplaceAllString(filepath.Base(root), "-"), "-.")
+	if name == "" {
+		return "project"
+	}
+	return name
+}
+
+// Replace the managed block for a project in an ssh config file, or append it, keeping the rest of the file
+//
+// The file is replaced through a temporary file, so that it is never left half written.
+func updateSSHConfigBlock(path string, project string, entries string) error {
+	// update the target of a linked config, rather than replacing the link
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		path = target
+	}
+
+	begin := UPCLOUD_SSHCONFIG_BEGIN + " " + project
+	end := UPCLOUD_SSHCONFIG_END + " " + project
+
+	mode := os.FileMode(0600)
+	existing := ""
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode()
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		existing = string(contents)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	block := begin + "\n" + entries + end + "\n"
+
+	lines := strings.SplitAfter(existing, "\n")
+	before := []string{}
+	after := []string{}
+	found := false
+	inside := false
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case !found && trimmed == begin:
+			found = true
+			inside = true
+		case inside && trimmed == end:
+			inside = false
+		case inside:
+		case found:
+			after = append(after, line)
+		default:
+			before = append(before, line)
+		}
+	}
+	if inside {
+		return errors.New("ssh config has a managed block without an end marker : " + path)
+	}
+
+	updated := strings.Join(before, "")
+	if !found && updated != "" {
+		if !strings.HasSuffix(updated, "\n") {
+			updated += "\n"
+		}
+		updated += "\n"
+	}
+	updated += block + strings.Join(after, "")
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	temp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	_, err = temp.WriteString(updated)
+	if err == nil {
+		err = temp.Chmod(mode)
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+	}
+	return err
+}
@@ -0,0 +1,177 @@
+package upcloud
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+/**
+ * Helpers for handling SSH public keys, as they are passed
+ * to UpCloud in the LoginUser part of a create request
+ */
+
+// Key types that UpCloud will accept as login user keys, including security key and certificate types
+var sshPublicKeyTypes = []string{
+	"ssh-rsa",
+	"ssh-dss",
+	"ssh-ed25519",
+	"ecdsa-sha2-nistp256",
+	"ecdsa-sha2-nistp384",
+	"ecdsa-sha2-nistp521",
+	"sk-ssh-ed25519@openssh.com",
+	"sk-ecdsa-sha2-nistp256@openssh.com",
+	"ssh-rsa-cert-v01@openssh.com",
+	"ssh-dss-cert-v01@openssh.com",
+	"ssh-ed25519-cert-v01@openssh.com",
+	"ecdsa-sha2-nistp256-cert-v01@openssh.com",
+	"ecdsa-sha2-nistp384-cert-v01@openssh.com",
+	"ecdsa-sha2-nistp521-cert-v01@openssh.com",
+	"sk-ssh-ed25519-cert-v01@openssh.com",
+	"sk-ecdsa-sha2-nistp256-cert-v01@openssh.com",
+}
+
+// Is a string a known key type?
+func isSSHPublicKeyType(keyType string) bool {
+	for _, match := range sshPublicKeyTypes {
+		if match == keyType {
+			return true
+		}
+	}
+	return false
+}
+
+// An authorized_keys entry: "[options] type base64 [comment]"
+type sshPublicKey struct {
+	Options string
+	Type    string
+	Data    string
+	Comment string
+}
+
+/**
+ * Parse an authorized_keys entry
+ *
+ * Options come before the key type, as a comma separated list which
+ * ends at the first whitespace outside of double quotes, as in
+ * from="10.0.0.1,10.0.0.2",no-pty ssh-ed25519 AAAA... comment
+ */
+func parseSSHPublicKey(line string) (sshPublicKey, error) {
+	key := sshPublicKey{}
+	line = strings.TrimSpace(line)
+
+	if fields := strings.Fields(line); len(fields) > 0 && !isSSHPublicKeyType(fields[0]) {
+		quoted := false
+		end := len(line)
+		for index, char := range line {
+			if char == '"' && (index == 0 || line[index-1] != '\\') {
+				quoted = !quoted
+			} else if !quoted && (char == ' ' || char == '\t') {
+				end = index
+				break
+			}
+		}
+		if quoted {
+			return key, errors.New("SSH key options have an unterminated quote")
+		}
+		key.Options = line[:end]
+		line = strings.TrimSpace(line[end:])
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return key, errors.New("SSH key should have at least a key type and key data")
+	}
+	key.Type = fields[0]
+	key.Data = fields[1]
+	if len(fields) > 2 {
+		key.Comment = strings.Join(fields[2:], " ")
+	}
+	return key, nil
+}
+
+// Check that a string is a parseable authorized_keys style public key ("[options] type base64 [comment]")
+func validateSSHPublicKey(line string) error {
+	key, err := parseSSHPublicKey(line)
+	if err != nil {
+		return err
+	}
+
+	if !isSSHPublicKeyType(key.Type) {
+		return errors.New("Unknown SSH key type: " + key.Type)
+	}
+
+	blob, err := base64.StdEncoding.DecodeString(key.Data)
+	if err != nil {
+		return errors.New("SSH key data is not valid base64")
+	}
+
+	// the key blob starts with a length prefixed copy of the key type
+	if len(blob) < 4 {
+		return errors.New("SSH key data is too short")
+	}
+	length := binary.BigEndian.Uint32(blob[:4])
+	if uint32(len(blob)-4) < length || string(blob[4:4+length]) != key.Type {
+		return errors.New("SSH key data does not match key type " + key.Type)
+	}
+
+	return nil
+}
+
+// The part of a public key that identifies it, without any options or comment
+func sshPublicKeyIdentity(line string) string {
+	key, err := parseSSHPublicKey(line)
+	if err != nil {
+		return strings.TrimSpace(line)
+	}
+	return key.Type + " " + key.Data
+}
+
+// Read the public keys from an authorized_keys style file, relative to the project, skipping blank and comment lines
+func readSSHPublicKeyFile(path string) ([]string, error) {
+	contents, err := ioutil.ReadFile(projectPath(path))
+	if err != nil {
+		return nil, err
+	}
+	keys := []string{}
+	for _, line := range strings.Split(string(contents), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keys = append(keys, line)
+	}
+	return keys, nil
+}
+
+// List the .pub files in a directory, relative to the project, in name order
+func sshPublicKeyDirFiles(dir string) ([]string, error) {
+	dir = projectPath(dir)
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".pub") {
+			files = append(files, filepath.Join(dir, entry.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Expand a leading ~/ to the user home directory, other paths are left as they are
+func expandHomePath(path string) string {
+	if strings.HasPrefix(path, "~/") {
+		if home := os.Getenv("HOME"); home != "" {
+			return filepath.Join(home, path[2:])
+		}
+	}
+	return path
+}
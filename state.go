@@ -0,0 +1,305 @@
+package upcloud
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+
+	upcloud "github.com/Jalle19/upcloud-go-sdk/upcloud"
+)
+
+const (
+	// Default path for the local provisioning state file
+	UPCLOUD_STATE_FILE_DEFAULT = ".radi/upcloud.state.yml"
+)
+
+/**
+ * A local record of the UpCloud resources provisioned for
+ * the servers in the project, keyed by the server id from
+ * the upcloud configuration.
+ *
+ * The state lets server definitions find their servers without
+ * matching titles, and is written after each provision up or
+ * down run.
+ *
+ * Storages of servers that are removed from the state are kept
+ * as released storages, so that they can be found as orphans.
+ *
+ * Replica group counts changed by scaling are kept in the state,
+ * and override the Count from the configuration.
+ */
+
+// The recorded resources for a single server definition
+type UpcloudStateServer struct {
+	UUID         string    `yaml:"UUID"`
+	Storages     []string  `yaml:"Storages,omitempty"`
+	FirewallHash string    `yaml:"FirewallHash,omitempty"`
+	Created      time.Time `yaml:"Created"`
+}
+
+// The local provisioning state for a project
+type UpcloudState struct {
+	lock sync.Mutex
+	path string
+
+	servers  map[string]UpcloudStateServer
+	released []string
+	scale    map[string]UpcloudStateScale
+}
+
+// Constructor for UpcloudState, with a path relative to the project root
+func New_UpcloudState(path string) *UpcloudState {
+	if path == "" {
+		path = UPCLOUD_STATE_FILE_DEFAULT
+	}
+	return &UpcloudState{
+		path:    projectPath(path),
+		servers: map[string]UpcloudStateServer{},
+		scale:   map[string]UpcloudStateScale{},
+	}
+}
+
+// The path to the state file
+func (state *UpcloudState) Path() string {
+	return state.path
+}
+
+// Read the state file, a missing file is an empty state
+func (state *UpcloudState) Load() error {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+
+	state.servers = map[string]UpcloudStateServer{}
+	state.released = []string{}
+	state.scale = map[string]UpcloudStateScale{}
+
+	source, err := ioutil.ReadFile(state.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	holder := struct {
+		Servers  map[string]UpcloudStateServer `yaml:"Servers"`
+		Released []string                      `yaml:"Released"`
+		Scale    map[string]UpcloudStateScale  `yaml:"Scale"`
+	}{}
+	if err := yaml.Unmarshal(source, &holder); err != nil {
+		return err
+	}
+	if holder.Servers != nil {
+		state.servers = holder.Servers
+	}
+	if holder.Released != nil {
+		state.released = holder.Released
+	}
+	if holder.Scale != nil {
+		state.scale = holder.Scale
+	}
+	log.WithFields(log.Fields{"path": state.path, "servers": len(state.servers)}).Debug("UpCloud state loaded")
+	return nil
+}
+
+// Write the state file
+func (state *UpcloudState) Save() error {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+
+	holder := struct {
+		Servers  map[string]UpcloudStateServer `yaml:"Servers"`
+		Released []string                      `yaml:"Released,omitempty"`
+		Scale    map[string]UpcloudStateScale  `yaml:"Scale,omitempty"`
+	}{Servers: state.servers, Released: state.released, Scale: state.scale}
+
+	source, err := yaml.Marshal(&holder)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(state.path), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(state.path, source, 0644); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{"path": state.path, "servers": len(state.servers)}).Debug("UpCloud state saved")
+	return nil
+}
+
+// Retrieve the recorded resources for a server id
+func (state *UpcloudState) Get(id string) (UpcloudStateServer, bool) {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+
+	server, found := state.servers[id]
+	return server, found
+}
+
+// Record the resources for a server id
+func (state *UpcloudState) Set(id string, server UpcloudStateServer) {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+
+	state.servers[id] = server
+}
+
+// Forget the resources for a server id
+func (state *UpcloudState) Remove(id string) {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+
+	delete(state.servers, id)
+}
+
+// The storages recorded as created for a server UUID
+func (state *UpcloudState) ServerStorages(uuid string) []string {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+
+	for _, server := range state.servers {
+		if server.UUID == uuid {
+			return append([]string{}, server.Storages...)
+		}
+	}
+	return []string{}
+}
+
+// The recorded server ids, sorted
+func (state *UpcloudState) Ids() []string {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+
+	ids := []string{}
+	for id := range state.servers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Forget the servers, from a list of ids, that are no longer in a server list, returning the forgotten ids
+//
+// The storages of forgotten servers are kept as released storages.
+func (state *UpcloudState) Prune(ids []string, servers *upcloud.Servers) []string {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+
+	live := map[string]bool{}
+	for _, server := range servers.Servers {
+		live[server.UUID] = true
+	}
+
+	pruned := []string{}
+	for _, id := range ids {
+		if recorded, found := state.servers[id]; found && !live[recorded.UUID] {
+			state.released = append(state.released, recorded.Storages...)
+			delete(state.servers, id)
+			pruned = append(pruned, id)
+		}
+	}
+	return pruned
+}
+
+// The storages released by servers that were forgotten
+func (state *UpcloudState) Released() []string {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+
+	return append([]string{}, state.released...)
+}
+
+// Forget a released storage
+func (state *UpcloudState) ForgetReleased(uuid string) {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+
+	released := []string{}
+	for _, existing := range state.released {
+		if existing != uuid {
+			released = append(released, existing)
+		}
+	}
+	state.released = released
+}
+
+/**
+ * The scaled count for a replica group, if it has been scaled
+ *
+ * The count overrides the Count in the project configuration only
+ * while the configured count is the one it was scaled from.  When
+ * the configuration changes, the scaled count is ignored, so that
+ * the new configured count is used.
+ */
+func (state *UpcloudState) Scale(group string, configured int) (int, bool) {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+
+	scaled, found := state.scale[group]
+	if !found || scaled.Configured != configured {
+		return 0, false
+	}
+	return scaled.Count, true
+}
+
+// Record the scaled count for a replica group, with the count configured when it was scaled
+func (state *UpcloudState) SetScale(group string, count int, configured int) {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+
+	state.scale[group] = UpcloudStateScale{Count: count, Configured: configured}
+}
+
+// Drop the scaled counts of groups which are no longer configured with the count they were scaled from, returning the dropped groups
+func (state *UpcloudState) PruneScale(groups map[string]int) []string {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+
+	dropped := []string{}
+	for group, scaled := range state.scale {
+		if configured, found := groups[group]; !found || configured != scaled.Configured {
+			delete(state.scale, group)
+			dropped = append(dropped, group)
+		}
+	}
+	sort.Strings(dropped)
+	return dropped
+}
+
+// A scaled replica group
+type UpcloudStateScale struct {
+	Count      int `yaml:"Count"`
+	Configured int `yaml:"Configured"`
+}
+
+// Build a state record for a server, with only the storages that were created for it
+func New_UpcloudStateServer(details upcloud.ServerDetails, storages []string, rules upcloud.FirewallRules) UpcloudStateServer {
+	return UpcloudStateServer{
+		UUID:         details.UUID,
+		Storages:     storages,
+		FirewallHash: firewallRulesHash(rules),
+		Created:      time.Now(),
+	}
+}
+
+// A stable hash of a set of firewall rules, used to detect changes
+func firewallRulesHash(rules upcloud.FirewallRules) string {
+	if len(rules.FirewallRules) == 0 {
+		return ""
+	}
+	source, err := json.Marshal(rules)
+	if err != nil {
+		log.WithError(err).Error("Could not hash firewall rules")
+		return ""
+	}
+	sum := sha256.Sum256(source)
+	return hex.EncodeToString(sum[:])
+}
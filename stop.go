@@ -0,0 +1,44 @@
+package upcloud
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+/**
+ * Stopping long running operations
+ *
+ * The watch and metrics operations run until they are stopped.  API
+ * callers can set a channel, and close it to stop the operation.
+ * Without one, the operation stops when the process is interrupted,
+ * and only takes over the interrupt signals while it runs.
+ */
+
+// An operation that runs until its stop channel is closed
+type UpcloudStoppableOperation interface {
+	// Set the channel that stops the operation when closed
+	SetStop(stop <-chan struct{})
+}
+
+// A stop channel that is closed when the process is interrupted, and a function that releases the signals
+func UpcloudInterruptStop() (<-chan struct{}, func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	stop := make(chan struct{})
+	released := make(chan struct{})
+	go func() {
+		select {
+		case <-signals:
+			close(stop)
+		case <-released:
+		}
+	}()
+
+	release := func() {
+		signal.Stop(signals)
+		close(released)
+	}
+	return stop, release
+}
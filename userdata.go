@@ -0,0 +1,148 @@
+package upcloud
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"text/template"
+
+	upcloud "github.com/Jalle19/upcloud-go-sdk/upcloud"
+)
+
+/**
+ * UserData rendering for yml server definitions
+ *
+ * UserData can be set inline, or loaded from a UserDataFile, relative
+ * to the project, and is rendered as a Go template with:
+ *
+ *   .Id .Group .Index .Hostname   the server being created
+ *   .Variables                    the project Variables
+ *   var "name"                    a project variable, which must exist
+ *   server "id"                   an already provisioned server, with
+ *                                 .UUID .Hostname .PublicIPv4
+ *                                 .PrivateIPv4 and .PublicIPv6
+ *
+ * In a dry run, servers that have not been provisioned yet render
+ * placeholder values, like "<db.PublicIPv4>".
+ */
+
+// Values available to a UserData template
+type userDataTemplateValues struct {
+	Id        string
+	Group     string
+	Index     int
+	Hostname  string
+	Variables map[string]string
+}
+
+// A provisioned server, as available to a UserData template
+type userDataServer struct {
+	Id          string
+	UUID        string
+	Hostname    string
+	PublicIPv4  string
+	PrivateIPv4 string
+	PublicIPv6  string
+}
+
+// Convert server details to the template server
+func newUserDataServer(id string, details *upcloud.ServerDetails) userDataServer {
+	return userDataServer{
+		Id:          id,
+		UUID:        details.UUID,
+		Hostname:    details.Hostname,
+		PublicIPv4:  serverAddress(details, upcloud.IPAddressAccessPublic, upcloud.IPAddressFamilyIPv4),
+		PrivateIPv4: serverAddress(details, upcloud.IPAddressAccessPrivate, upcloud.IPAddressFamilyIPv4),
+		PublicIPv6:  serverAddress(details, upcloud.IPAddressAccessPublic, upcloud.IPAddressFamilyIPv6),
+	}
+}
+
+// A server that has not been provisioned, with placeholder values for a dry run
+func newUserDataPlaceholderServer(id string) userDataServer {
+	placeholder := func(field string) string {
+		return "<" + id + "." + field + ">"
+	}
+	return userDataServer{
+		Id:          id,
+		UUID:        placeholder("UUID"),
+		Hostname:    placeholder("Hostname"),
+		PublicIPv4:  placeholder("PublicIPv4"),
+		PrivateIPv4: placeholder("PrivateIPv4"),
+		PublicIPv6:  placeholder("PublicIPv6"),
+	}
+}
+
+// Render the UserData for the server
+func (server *Yml_UpcloudFactory_Server) UserData() (string, error) {
+	return server.renderUserData(false)
+}
+
+// Render the UserData for a dry run, with placeholders for servers that have not been provisioned
+func (server *Yml_UpcloudFactory_Server) DryRunUserData() (string, error) {
+	return server.renderUserData(true)
+}
+
+// Render the UserData template
+func (server *Yml_UpcloudFactory_Server) renderUserData(dryRun bool) (string, error) {
+	source := server.serverDefinition.UserData
+	if file := server.serverDefinition.UserDataFile; file != "" {
+		if source != "" {
+			return "", errors.New("Server " + server.id + " has both UserData and UserDataFile")
+		}
+		contents, err := ioutil.ReadFile(projectPath(file))
+		if err != nil {
+			return "", err
+		}
+		source = string(contents)
+	}
+	if source == "" {
+		return "", nil
+	}
+
+	variables := server.factory.Variables
+	if variables == nil {
+		variables = map[string]string{}
+	}
+	funcs := template.FuncMap{
+		"var": func(name string) (string, error) {
+			if value, found := variables[name]; found {
+				return value, nil
+			}
+			return "", errors.New("Unknown project variable : " + name)
+		},
+		"server": func(id string) (userDataServer, error) {
+			serverDefinitions := server.factory.ServerDefinitions()
+			serverDefinition, found := serverDefinitions.Get(id)
+			if !found {
+				return userDataServer{}, errors.New("Unknown server : " + id)
+			}
+			details, err := serverDefinition.GetServerDetails()
+			if err != nil && dryRun {
+				return newUserDataPlaceholderServer(id), nil
+			} else if err != nil {
+				return userDataServer{}, errors.New("Server has not been provisioned : " + id)
+			}
+			return newUserDataServer(id, details), nil
+		},
+	}
+
+	userDataTemplate, err := template.New(server.id).Funcs(funcs).Option("missingkey=error").Parse(source)
+	if err != nil {
+		return "", err
+	}
+
+	request := server.CreateServerRequest()
+	values := userDataTemplateValues{
+		Id:        server.id,
+		Group:     server.group,
+		Index:     server.replica,
+		Hostname:  request.Hostname,
+		Variables: variables,
+	}
+
+	var rendered bytes.Buffer
+	if err := userDataTemplate.Execute(&rendered, values); err != nil {
+		return "", err
+	}
+	return rendered.String(), nil
+}
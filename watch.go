@@ -0,0 +1,313 @@
+package upcloud
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	api_operation "github.com/wunderkraut/radi-api/operation"
+	api_property "github.com/wunderkraut/radi-api/property"
+	api_result "github.com/wunderkraut/radi-api/result"
+	api_usage "github.com/wunderkraut/radi-api/usage"
+)
+
+const (
+	// Default time between polls of a watch
+	UPCLOUD_WATCH_INTERVAL = 10 * time.Second
+	// Shortest allowed time between polls, to stay clear of API limits
+	UPCLOUD_WATCH_INTERVAL_MIN = 2 * time.Second
+
+	UPCLOUD_WATCH_FORMAT_TEXT = "text"
+	UPCLOUD_WATCH_FORMAT_JSON = "json"
+)
+
+/**
+ * Watching project servers for changes
+ */
+
+// Server watch operation
+type UpcloudMonitorWatchOperation struct {
+	BaseUpcloudServiceOperation
+
+	// closed to stop the operation, which otherwise stops when the process is interrupted
+	stop <-chan struct{}
+	// polls the project servers, watch.snapshot unless replaced
+	poll func() (map[string]watchServer, error)
+}
+
+// Set the channel that stops the operation when closed
+func (watch *UpcloudMonitorWatchOperation) SetStop(stop <-chan struct{}) {
+	watch.stop = stop
+}
+
+// Return the string machinename/id of the Operation
+func (watch *UpcloudMonitorWatchOperation) Id() string {
+	return "upcloud.monitor.watch"
+}
+
+// Return a user readable string label for the Operation
+func (watch *UpcloudMonitorWatchOperation) Label() string {
+	return "Watch UpCloud servers"
+}
+
+// return a multiline string description for the Operation
+func (watch *UpcloudMonitorWatchOperation) Description() string {
+	return "Poll the project servers, and report each change of state, progress, IPs or tags."
+}
+
+// return a multiline string man page for the Operation
+func (watch *UpcloudMonitorWatchOperation) Help() string {
+	return `The watch runs until it is interrupted, or until the stop channel
+set by an API caller is closed, and then stops cleanly.
+
+Events are written as readable lines (text, default), or as
+newline delimited JSON (json), one event per changed field.`
+}
+
+// Is this operation meant to be used only inside the API
+func (watch *UpcloudMonitorWatchOperation) Usage() api_usage.Usage {
+	return api_operation.Usage_External()
+}
+
+// Run a validation check on the Operation
+func (watch *UpcloudMonitorWatchOperation) Validate() api_result.Result {
+	return api_result.MakeSuccessfulResult()
+}
+
+// What settings/values does the Operation provide to an implemenentor
+func (watch *UpcloudMonitorWatchOperation) Properties() api_property.Properties {
+	props := api_property.New_SimplePropertiesEmpty()
+
+	props.Add(api_property.Property(&UpcloudIntervalProperty{}))
+	props.Add(api_property.Property(&UpcloudFormatProperty{}))
+	props.Add(api_property.Property(&UpcloudOutputProperty{}))
+
+	return props.Properties()
+}
+
+// Execute the Operation
+func (watch *UpcloudMonitorWatchOperation) Exec(props api_property.Properties) api_result.Result {
+	res := api_result.New_StandardResult()
+
+	interval := UPCLOUD_WATCH_INTERVAL
+	if intervalProp, found := props.Get(UPCLOUD_INTERVAL_PROPERTY); found && intervalProp.Get().(string) != "" {
+		if value, err := time.ParseDuration(intervalProp.Get().(string)); err == nil {
+			interval = value
+		} else {
+			res.AddError(err)
+			res.AddError(errors.New("Invalid watch interval : " + intervalProp.Get().(string)))
+			res.MarkFailed()
+			res.MarkFinished()
+			return res.Result()
+		}
+	}
+	if interval < UPCLOUD_WATCH_INTERVAL_MIN {
+		interval = UPCLOUD_WATCH_INTERVAL_MIN
+	}
+	format := UPCLOUD_WATCH_FORMAT_TEXT
+	if formatProp, found := props.Get(UPCLOUD_FORMAT_PROPERTY); found && formatProp.Get().(string) != "" {
+		format = formatProp.Get().(string)
+	}
+	if format != UPCLOUD_WATCH_FORMAT_TEXT && format != UPCLOUD_WATCH_FORMAT_JSON {
+		res.AddError(errors.New("Unknown watch format : " + format))
+		res.MarkFailed()
+		res.MarkFinished()
+		return res.Result()
+	}
+	output := ""
+	if outputProp, found := props.Get(UPCLOUD_OUTPUT_PROPERTY); found {
+		output = outputProp.Get().(string)
+	}
+
+	writer, err := openOutput(output)
+	if err != nil {
+		res.AddError(err)
+		res.MarkFailed()
+		res.MarkFinished()
+		return res.Result()
+	}
+	defer writer.Close()
+
+	stop := watch.stop
+	if stop == nil {
+		interrupt, release := UpcloudInterruptStop()
+		defer release()
+		stop = interrupt
+	}
+	poll := watch.poll
+	if poll == nil {
+		poll = watch.snapshot
+	}
+
+	log.WithFields(log.Fields{"interval": interval, "format": format}).Info("WATCH: Watching project servers, interrupt to stop")
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var previous map[string]watchServer
+	for {
+		current, err := poll()
+		if err != nil {
+			// a failed poll is reported, and the watch carries on
+			log.WithError(err).Warn("WATCH: Could not poll UpCloud servers")
+		} else {
+			if previous != nil {
+				for _, event := range watchEvents(previous, current) {
+					if err := event.Write(writer, format); err != nil {
+						res.AddError(err)
+						res.MarkFailed()
+						res.MarkFinished()
+						return res.Result()
+					}
+				}
+			}
+			previous = current
+		}
+
+		select {
+		case <-stop:
+			log.Info("WATCH: Stopped")
+			res.MarkSuccess()
+			res.MarkFinished()
+			return res.Result()
+		case <-ticker.C:
+		}
+	}
+}
+
+// The watched values of a project server
+type watchServer struct {
+	Id       string
+	UUID     string
+	State    string
+	Progress int
+	IPs      []string
+	Tags     []string
+}
+
+// Poll the current values of the created project servers, by server id
+func (watch *UpcloudMonitorWatchOperation) snapshot() (map[string]watchServer, error) {
+	service := watch.ServiceWrapper()
+	serverDefinitions := watch.ServerDefinitions()
+
+	servers, err := service.GetServers()
+	if err != nil {
+		return nil, err
+	}
+	addresses, err := service.GetIPAddresses()
+	if err != nil {
+		return nil, err
+	}
+	ips := map[string][]string{}
+	for _, ip := range addresses.IPAddresses {
+		ips[ip.ServerUUID] = append(ips[ip.ServerUUID], ip.Address)
+	}
+
+	snapshot := map[string]watchServer{}
+	for _, id := range serverDefinitions.Order() {
+		serverDefinition, _ := serverDefinitions.Get(id)
+		uuid, err := serverDefinition.UUID()
+		if err != nil {
+			continue
+		}
+		for _, server := range servers.Servers {
+			if server.UUID != uuid {
+				continue
+			}
+			watched := watchServer{
+				Id:       id,
+				UUID:     uuid,
+				State:    server.State,
+				Progress: server.Progress,
+				IPs:      append([]string{}, ips[uuid]...),
+				Tags:     append([]string{}, server.Tags...),
+			}
+			sort.Strings(watched.IPs)
+			sort.Strings(watched.Tags)
+			snapshot[id] = watched
+			break
+		}
+	}
+	return snapshot, nil
+}
+
+// A change to a watched server
+type watchEvent struct {
+	Time  time.Time `json:"time"`
+	Id    string    `json:"id"`
+	UUID  string    `json:"uuid"`
+	Field string    `json:"field"`
+	Old   string    `json:"old"`
+	New   string    `json:"new"`
+}
+
+// Write the event as a line of text or JSON
+func (event watchEvent) Write(writer io.Writer, format string) error {
+	line := ""
+	if format == UPCLOUD_WATCH_FORMAT_JSON {
+		source, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		line = string(source)
+	} else {
+		line = event.Time.Format(time.RFC3339) + " " + event.Id + " (" + event.UUID + ") " + event.Field + ": " + event.Old + " -> " + event.New
+	}
+	_, err := io.WriteString(writer, line+"\n")
+	return err
+}
+
+// Compare two snapshots, returning an event for each changed field, in server id order
+func watchEvents(previous map[string]watchServer, current map[string]watchServer) []watchEvent {
+	now := time.Now()
+	events := []watchEvent{}
+
+	ids := []string{}
+	for id := range previous {
+		ids = append(ids, id)
+	}
+	for id := range current {
+		if _, found := previous[id]; !found {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		before, existed := previous[id]
+		after, exists := current[id]
+		event := func(uuid string, field string, from string, to string) {
+			events = append(events, watchEvent{Time: now, Id: id, UUID: uuid, Field: field, Old: from, New: to})
+		}
+
+		switch {
+		case !existed:
+			event(after.UUID, "server", "", "created")
+		case !exists:
+			event(before.UUID, "server", "", "removed")
+		default:
+			if before.UUID != after.UUID {
+				event(after.UUID, "uuid", before.UUID, after.UUID)
+			}
+			if before.State != after.State {
+				event(after.UUID, "state", before.State, after.State)
+			}
+			if before.Progress != after.Progress {
+				event(after.UUID, "progress", strconv.Itoa(before.Progress), strconv.Itoa(after.Progress))
+			}
+			if from, to := strings.Join(before.IPs, ","), strings.Join(after.IPs, ","); from != to {
+				event(after.UUID, "ips", from, to)
+			}
+			if from, to := strings.Join(before.Tags, ","), strings.Join(after.Tags, ","); from != to {
+				event(after.UUID, "tags", from, to)
+			}
+		}
+	}
+	return events
+}
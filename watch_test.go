@@ -0,0 +1,73 @@
+package upcloud
+
+import (
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	api_result "github.com/wunderkraut/radi-api/result"
+)
+
+// Run the watch Exec, failing if it doesn't return in time
+func watchTestExec(t *testing.T, watch *UpcloudMonitorWatchOperation) api_result.Result {
+	props := watch.Properties()
+	if intervalProp, found := props.Get(UPCLOUD_INTERVAL_PROPERTY); found {
+		intervalProp.Set(UPCLOUD_WATCH_INTERVAL_MIN.String())
+	}
+
+	done := make(chan api_result.Result, 1)
+	go func() {
+		done <- watch.Exec(props)
+	}()
+	select {
+	case res := <-done:
+		return res
+	case <-time.After(5 * time.Second):
+		t.Fatal("the watch did not stop")
+	}
+	return nil
+}
+
+func TestWatchExecStopChannel(t *testing.T) {
+	stop := make(chan struct{})
+	polls := 0
+
+	watch := &UpcloudMonitorWatchOperation{}
+	watch.SetStop(stop)
+	watch.poll = func() (map[string]watchServer, error) {
+		polls++
+		if polls == 1 {
+			close(stop)
+		}
+		return map[string]watchServer{}, nil
+	}
+
+	res := watchTestExec(t, watch)
+	if !res.Success() {
+		t.Errorf("a stopped watch should succeed: %v", res.Errors())
+	}
+	if polls != 1 {
+		t.Errorf("the watch polled %d times after it was stopped", polls)
+	}
+}
+
+func TestWatchExecInterrupt(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("a process can't interrupt itself on windows")
+	}
+
+	watch := &UpcloudMonitorWatchOperation{}
+	watch.poll = func() (map[string]watchServer, error) {
+		// the watch has no stop channel, so it stops on interrupt
+		if process, err := os.FindProcess(os.Getpid()); err == nil {
+			process.Signal(os.Interrupt)
+		}
+		return map[string]watchServer{}, nil
+	}
+
+	res := watchTestExec(t, watch)
+	if !res.Success() {
+		t.Errorf("an interrupted watch should succeed: %v", res.Errors())
+	}
+}